@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// AdminBootstrapSubs lists the subs of the users who are made admins when
+	// they log in. It's how operators get their first admin, who can then
+	// manage the portal via the admin endpoints. The point of this var is to
+	// be overridable via .env.
+	AdminBootstrapSubs []string
+
+	// ErrInsufficientPrivileges is returned when the user doesn't have the
+	// role required for accessing an endpoint.
+	ErrInsufficientPrivileges = errors.New("insufficient privileges")
+
+	// defaultRevocationDuration is the amount of time for which we keep a
+	// token revoked when the admin doesn't specify its expiration time. We
+	// reject all tokens whose Kratos session has expired, so this only needs
+	// to be longer than Kratos' session lifespan.
+	defaultRevocationDuration = 30 * 24 * time.Hour
+)
+
+// bootstrapAdmin makes the given user an admin if their sub is listed in
+// AdminBootstrapSubs and they aren't one already.
+func (api *API) bootstrapAdmin(ctx context.Context, u *database.User) error {
+	if u.Role >= database.RoleAdmin {
+		return nil
+	}
+	for _, sub := range AdminBootstrapSubs {
+		if sub != u.Sub {
+			continue
+		}
+		err := api.staticDB.UserSetRole(ctx, u, database.RoleAdmin)
+		if err != nil {
+			return errors.AddContext(err, "failed to make the user an admin")
+		}
+		api.staticLogger.Infof("User %s is now an admin because their sub is listed in the bootstrap admins.", u.ID.Hex())
+		return nil
+	}
+	return nil
+}
+
+// adminTokenRevokeHandler revokes the token with the given `jti`, e.g. because
+// it has been stolen.
+func (api *API) adminTokenRevokeHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	jti := req.Form.Get("jti")
+	if jti == "" {
+		api.WriteError(w, errors.New("missing parameter 'jti'"), http.StatusBadRequest)
+		return
+	}
+	expiresAt := time.Now().UTC().Add(defaultRevocationDuration)
+	if expStr := req.Form.Get("exp"); expStr != "" {
+		exp, err := strconv.ParseInt(expStr, 10, 64)
+		if err != nil {
+			api.WriteError(w, errors.AddContext(err, "invalid parameter 'exp'"), http.StatusBadRequest)
+			return
+		}
+		expiresAt = time.Unix(exp, 0).UTC()
+	}
+	err := api.revokeJTI(req.Context(), jti, expiresAt)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteSuccess(w)
+}
+
+// adminUsersHandler returns a page of all users.
+func (api *API) adminUsersHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	offset, err1 := fetchOffset(req.Form)
+	pageSize, err2 := fetchPageSize(req.Form)
+	if err := errors.Compose(err1, err2); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	users, total, err := api.staticDB.Users(req.Context(), offset, pageSize)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	response := database.UsersResponseDTO{
+		Items:    users,
+		Offset:   offset,
+		PageSize: pageSize,
+		Count:    total,
+	}
+	api.WriteJSON(w, response)
+}
+
+// adminUserHandler returns the user identified by the `id` param.
+func (api *API) adminUserHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	u, ok := api.adminUserFromParams(w, req, ps)
+	if !ok {
+		return
+	}
+	api.WriteJSON(w, u)
+}
+
+// adminUserPUTHandler changes the tier and/or the subscription expiration of
+// the user identified by the `id` param. Parameters which are not passed are
+// left unchanged. An empty `subscribedUntil` clears the subscription
+// expiration. Upgrades apply immediately, downgrades at the end of the user's
+// current billing period, unless `immediate` is true.
+func (api *API) adminUserPUTHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	u, ok := api.adminUserFromParams(w, req, ps)
+	if !ok {
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	tier := u.Tier
+	if _, exists := req.Form["tier"]; exists {
+		var err error
+		tier, err = strconv.Atoi(req.Form.Get("tier"))
+		if err != nil {
+			api.WriteError(w, errors.New("invalid parameter 'tier'"), http.StatusBadRequest)
+			return
+		}
+	}
+	until := u.SubscribedUntil
+	if _, exists := req.Form["subscribedUntil"]; exists {
+		until = time.Time{}
+		if s := req.Form.Get("subscribedUntil"); s != "" {
+			var err error
+			until, err = time.Parse(time.RFC3339, s)
+			if err != nil {
+				api.WriteError(w, errors.AddContext(err, "invalid parameter 'subscribedUntil'"), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	var immediate bool
+	if s := req.Form.Get("immediate"); s != "" {
+		var err error
+		immediate, err = strconv.ParseBool(s)
+		if err != nil {
+			api.WriteError(w, errors.New("invalid parameter 'immediate'"), http.StatusBadRequest)
+			return
+		}
+	}
+	if !api.changeSubscription(w, req, u, tier, until, database.SubscriptionSourceAdmin, immediate) {
+		return
+	}
+	api.WriteJSON(w, u)
+}
+
+// adminUserDELETEHandler deletes the user identified by the `id` param
+// together with all of their data.
+func (api *API) adminUserDELETEHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	u, ok := api.adminUserFromParams(w, req, ps)
+	if !ok {
+		return
+	}
+	err := api.staticDB.UserDelete(req.Context(), u)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteSuccess(w)
+}
+
+// adminUserStatsHandler returns statistics about the user identified by the
+// `id` param. It takes the same params as userStatsHandler.
+func (api *API) adminUserStatsHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	u, ok := api.adminUserFromParams(w, req, ps)
+	if !ok {
+		return
+	}
+	period, ok := api.statsPeriod(w, req, *u)
+	if !ok {
+		return
+	}
+	api.writeStats(w, req, *u, period)
+}
+
+// adminUserSubscriptionChangesHandler returns the subscription history of the
+// user identified by the `id` param.
+func (api *API) adminUserSubscriptionChangesHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	u, ok := api.adminUserFromParams(w, req, ps)
+	if !ok {
+		return
+	}
+	api.writeSubscriptionChanges(w, req, *u)
+}
+
+// adminUserUploadsHandler returns the uploads made by the user identified by
+// the `id` param.
+func (api *API) adminUserUploadsHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	u, ok := api.adminUserFromParams(w, req, ps)
+	if !ok {
+		return
+	}
+	api.writeUploads(w, req, *u)
+}
+
+// adminUserDownloadsHandler returns the downloads made by the user identified
+// by the `id` param.
+func (api *API) adminUserDownloadsHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	u, ok := api.adminUserFromParams(w, req, ps)
+	if !ok {
+		return
+	}
+	api.writeDownloads(w, req, *u)
+}
+
+// adminUserFromParams fetches the user identified by the `id` param. If that
+// fails it writes the error to the response and returns false.
+func (api *API) adminUserFromParams(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (*database.User, bool) {
+	u, err := api.userByIDOrSub(req.Context(), ps.ByName("id"))
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return u, true
+}
+
+// userByIDOrSub fetches the user with the given id, which can be either their
+// hex-encoded ObjectID or their sub. The two formats don't overlap because subs
+// are UUIDs.
+func (api *API) userByIDOrSub(ctx context.Context, id string) (*database.User, error) {
+	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+		return api.staticDB.UserByID(ctx, oid)
+	}
+	return api.staticDB.UserBySub(ctx, id, false)
+}
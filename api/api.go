@@ -8,6 +8,7 @@ import (
 	"github.com/NebulousLabs/skynet-accounts/database"
 	"github.com/NebulousLabs/skynet-accounts/metafetcher"
 
+	"github.com/gorilla/securecookie"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"gitlab.com/NebulousLabs/errors"
@@ -19,6 +20,21 @@ type API struct {
 	staticMF     *metafetcher.MetaFetcher
 	staticRouter *httprouter.Router
 	staticLogger *logrus.Logger
+	staticAuth   Authenticator
+
+	// staticCookieCodecs encode and decode the cookies which hold the users'
+	// JWTs. The first codec is used for encoding. See cookieCodecsFromEnv.
+	staticCookieCodecs    []securecookie.Codec
+	staticRevokedTokens   *revocationCache
+	staticRevokedSessions *revocationCache
+	staticSessionTouches  *keyedThrottle
+	staticStatsCache      *statsCache
+	staticUsageChecks     *keyedThrottle
+}
+
+// errorWrap is a helper type for converting an `error` struct to JSON.
+type errorWrap struct {
+	Message string `json:"message"`
 }
 
 // ctxValue is a helper type which makes it safe to register values in the
@@ -26,30 +42,50 @@ type API struct {
 // to get our value or accidentally overwrite it.
 type ctxValue string
 
-// New returns a new initialised API.
-func New(db *database.DB, mf *metafetcher.MetaFetcher, logger *logrus.Logger) (*API, error) {
+// New returns a new initialised API. The authenticator provides the keys for
+// validating JWTs. If it's nil we use the JWKS exposed by Oathkeeper.
+func New(db *database.DB, mf *metafetcher.MetaFetcher, logger *logrus.Logger, auth Authenticator) (*API, error) {
 	if db == nil {
 		return nil, errors.New("no DB provided")
 	}
 	if logger == nil {
 		logger = logrus.New()
 	}
+	if auth == nil {
+		auth = NewRemoteJWKSAuthenticator("http://"+OathkeeperAddr+"/.well-known/jwks.json", logger)
+	}
+	codecs, err := cookieCodecsFromEnv()
+	if err != nil {
+		return nil, errors.AddContext(err, "invalid cookie configuration")
+	}
+	if err = validateCORSOrigins(CORSAllowedOrigins); err != nil {
+		return nil, errors.AddContext(err, "invalid CORS configuration")
+	}
 	router := httprouter.New()
 	router.RedirectTrailingSlash = true
+	router.GlobalOPTIONS = http.HandlerFunc(corsPreflightHandler)
 
 	api := &API{
 		staticDB:     db,
 		staticMF:     mf,
 		staticRouter: router,
 		staticLogger: logger,
+		staticAuth:   auth,
+
+		staticCookieCodecs:    codecs,
+		staticRevokedTokens:   newRevocationCache(),
+		staticRevokedSessions: newRevocationCache(),
+		staticSessionTouches:  newKeyedThrottle(sessionTouchInterval),
+		staticStatsCache:      newStatsCache(),
+		staticUsageChecks:     newKeyedThrottle(usageCheckInterval),
 	}
 	api.buildHTTPRoutes()
 	return api, nil
 }
 
-// Router exposed the internal httprouter struct.
-func (api *API) Router() *httprouter.Router {
-	return api.staticRouter
+// Router exposes the internal httprouter struct, wrapped in a CORS layer.
+func (api *API) Router() http.Handler {
+	return withCORS(api.staticRouter)
 }
 
 // WriteError an error to the API caller.
@@ -57,7 +93,7 @@ func (api *API) WriteError(w http.ResponseWriter, err error, code int) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(code)
 	api.staticLogger.Debugln(code, err)
-	encodingErr := json.NewEncoder(w).Encode(err)
+	encodingErr := json.NewEncoder(w).Encode(errorWrap{Message: err.Error()})
 	if _, isJSONErr := encodingErr.(*json.SyntaxError); isJSONErr {
 		// Marshalling should only fail in the event of a developer error.
 		// Specifically, only non-marshallable types should cause an error here.
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyHeader is the name of the request header which carries the API key.
+const APIKeyHeader = "Skynet-Api-Key"
+
+// apiKeyPOSTResponse is the response of a successful API key creation. It's
+// the only time we return the key itself.
+type apiKeyPOSTResponse struct {
+	database.APIKey
+	Key string `json:"key"`
+}
+
+// userAPIKeysPOSTHandler creates a new API key for the current user.
+func (api *API) userAPIKeysPOSTHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	if err = req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, true)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	ak, key, err := api.staticDB.APIKeyCreate(req.Context(), *u, req.Form.Get("name"), req.Form["scope"])
+	if errors.Contains(err, database.ErrInvalidScope) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, apiKeyPOSTResponse{APIKey: *ak, Key: key})
+}
+
+// userAPIKeysGETHandler lists all API keys of the current user.
+func (api *API) userAPIKeysGETHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, true)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	keys, err := api.staticDB.APIKeysByUser(req.Context(), *u)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, keys)
+}
+
+// userAPIKeysDELETEHandler revokes one of the current user's API keys.
+func (api *API) userAPIKeysDELETEHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "invalid API key id"), http.StatusBadRequest)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, false)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	err = api.staticDB.APIKeyDelete(req.Context(), *u, id)
+	if errors.Contains(err, database.ErrAPIKeyNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteSuccess(w)
+}
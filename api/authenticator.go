@@ -0,0 +1,159 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat/go-jwx/jwk"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// Authenticator provides the public keys we use for verifying the
+	// signatures of the JWTs we receive.
+	Authenticator interface {
+		// Key returns the public key which should be used for verifying the
+		// signature of the given token.
+		Key(token *jwt.Token) (interface{}, error)
+	}
+
+	// RemoteJWKSAuthenticator uses the keys from a JWKS served over HTTP, e.g.
+	// by Oathkeeper. It caches the keys and refreshes them periodically. See
+	// jwksCache.
+	RemoteJWKSAuthenticator struct {
+		staticCache  *jwksCache
+		staticLogger *logrus.Logger
+	}
+
+	// JWKSFileAuthenticator uses the keys from a JWKS stored on disk. This
+	// allows running the service without Oathkeeper, e.g. in air-gapped or
+	// development environments.
+	JWKSFileAuthenticator struct {
+		staticKeys *jwk.Set
+	}
+
+	// StaticKeyAuthenticator uses a single public key for all tokens,
+	// regardless of their `kid`. The key can be an RSA, ECDSA or Ed25519 key.
+	StaticKeyAuthenticator struct {
+		staticKey interface{}
+	}
+)
+
+// NewRemoteJWKSAuthenticator returns a new RemoteJWKSAuthenticator which uses
+// the JWKS served on the given URL. It starts a background thread which keeps
+// the keys fresh.
+func NewRemoteJWKSAuthenticator(jwksURL string, logger *logrus.Logger) *RemoteJWKSAuthenticator {
+	if logger == nil {
+		logger = logrus.New()
+	}
+	a := &RemoteJWKSAuthenticator{
+		staticCache:  &jwksCache{staticURL: jwksURL},
+		staticLogger: logger,
+	}
+	go a.staticCache.threadedRefresh(logger)
+	return a
+}
+
+// Key returns the key with the same `kid` as the token. If no such key exists
+// we force a refetch of the keys because they might have been rotated.
+func (a *RemoteJWKSAuthenticator) Key(token *jwt.Token) (interface{}, error) {
+	kid, err := tokenKeyID(token)
+	if err != nil {
+		return nil, err
+	}
+	keySet, err := a.staticCache.managedKeys(a.staticLogger)
+	if err != nil {
+		return nil, err
+	}
+	keys := keySet.LookupKeyID(kid)
+	if len(keys) == 0 {
+		a.staticLogger.Traceln("no key found for kid, forcing a JWKS refetch:", kid)
+		keySet, err = a.staticCache.managedRefresh(a.staticLogger, true)
+		if err != nil {
+			return nil, err
+		}
+		keys = keySet.LookupKeyID(kid)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no suitable keys found")
+	}
+	return keys[0].Materialize()
+}
+
+// NewJWKSFileAuthenticator returns a new JWKSFileAuthenticator which uses the
+// JWKS stored in the given file.
+func NewJWKSFileAuthenticator(path string) (*JWKSFileAuthenticator, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to read JWKS file")
+	}
+	set, err := jwk.Parse(b)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse JWKS file")
+	}
+	return &JWKSFileAuthenticator{staticKeys: set}, nil
+}
+
+// Key returns the key with the same `kid` as the token.
+func (a *JWKSFileAuthenticator) Key(token *jwt.Token) (interface{}, error) {
+	kid, err := tokenKeyID(token)
+	if err != nil {
+		return nil, err
+	}
+	keys := a.staticKeys.LookupKeyID(kid)
+	if len(keys) == 0 {
+		return nil, errors.New("no suitable keys found")
+	}
+	return keys[0].Materialize()
+}
+
+// NewStaticKeyAuthenticator returns a new StaticKeyAuthenticator which uses the
+// given PEM-encoded public key. The key needs to be in PKIX format, i.e. its
+// PEM block type needs to be "PUBLIC KEY".
+func NewStaticKeyAuthenticator(pemBytes []byte) (*StaticKeyAuthenticator, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse public key")
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported key type %T", key))
+	}
+	return &StaticKeyAuthenticator{staticKey: key}, nil
+}
+
+// NewStaticKeyAuthenticatorFromFile returns a new StaticKeyAuthenticator which
+// uses the PEM-encoded public key stored in the given file.
+func NewStaticKeyAuthenticatorFromFile(path string) (*StaticKeyAuthenticator, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to read public key file")
+	}
+	return NewStaticKeyAuthenticator(b)
+}
+
+// Key returns the static key.
+func (a *StaticKeyAuthenticator) Key(_ *jwt.Token) (interface{}, error) {
+	return a.staticKey, nil
+}
+
+// tokenKeyID returns the `kid` header of the token.
+func tokenKeyID(token *jwt.Token) (string, error) {
+	if reflect.ValueOf(token.Header["kid"]).Kind() != reflect.String {
+		return "", errors.New("invalid jwk header - the kid field is not a string")
+	}
+	return token.Header["kid"].(string), nil
+}
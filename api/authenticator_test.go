@@ -0,0 +1,87 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// TestStaticKeyAuthenticator ensures ValidateToken accepts tokens signed with
+// ECDSA and Ed25519 keys provided by a StaticKeyAuthenticator, and rejects
+// tokens signed with other keys or with symmetric signing methods.
+func TestStaticKeyAuthenticator(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherEdKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		pub    interface{}
+		method jwt.SigningMethod
+		key    interface{}
+		valid  bool
+	}{
+		{name: "ES256", pub: &ecKey.PublicKey, method: jwt.SigningMethodES256, key: ecKey, valid: true},
+		{name: "EdDSA", pub: edPub, method: SigningMethodEdDSA, key: edKey, valid: true},
+		{name: "EdDSA wrong key", pub: edPub, method: SigningMethodEdDSA, key: otherEdKey, valid: false},
+		{name: "HS256", pub: edPub, method: jwt.SigningMethodHS256, key: []byte(edPub), valid: false},
+	}
+	for _, tt := range tests {
+		auth, err := NewStaticKeyAuthenticator(pemPublicKey(t, tt.pub))
+		if err != nil {
+			t.Fatal(tt.name, err)
+		}
+		tokenStr, err := jwt.NewWithClaims(tt.method, testClaims()).SignedString(tt.key)
+		if err != nil {
+			t.Fatal(tt.name, err)
+		}
+		_, err = ValidateToken(auth, tokenStr)
+		if tt.valid && err != nil {
+			t.Errorf("%s: expected the token to be valid, got %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected the token to be invalid", tt.name)
+		}
+	}
+}
+
+// pemPublicKey returns the PEM encoding of the given public key.
+func pemPublicKey(t *testing.T, pub interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// testClaims returns a set of claims which passes validateClaims.
+func testClaims() jwt.MapClaims {
+	now := time.Now().UTC()
+	return jwt.MapClaims{
+		"exp": now.Add(time.Hour).Unix(),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"jti": "1e5872ae-71d8-49ec-a550-4fc6163cbbf2",
+		"sub": "695725d4-a345-4e68-919a-7395cb68484c",
+		"session": map[string]interface{}{
+			"active":     true,
+			"expires_at": now.Add(time.Hour).Format(time.RFC3339Nano),
+		},
+	}
+}
@@ -1,18 +1,23 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/gorilla/securecookie"
-	"github.com/joho/godotenv"
+	"gitlab.com/NebulousLabs/errors"
 )
 
 const (
 	// CookieName is the name of the cookie where we store the user's JWT token.
 	CookieName = "skynet-jwt"
 
+	// cookieKeyLen is the length of the keys we use for hashing and encrypting
+	// cookies. Longer keys are truncated to this length.
+	cookieKeyLen = 32
+
 	// envCookieDomain holds the name of the environment variable for the
 	// domain name of the portal
 	envCookieDomain = "COOKIE_DOMAIN"
@@ -22,23 +27,70 @@ const (
 	// envCookieEncKey holds the name of the env var which holds the key we use
 	// to encrypt cookies.
 	envCookieEncKey = "COOKIE_ENC_KEY"
+	// envCookiePrevHashKey holds the name of the env var which holds the key
+	// we used to hash cookies before the last key rotation.
+	envCookiePrevHashKey = "COOKIE_PREV_HASH_KEY"
+	// envCookiePrevEncKey holds the name of the env var which holds the key we
+	// used to encrypt cookies before the last key rotation.
+	envCookiePrevEncKey = "COOKIE_PREV_ENC_KEY"
 )
 
-var (
-	secureCookie = func() *securecookie.SecureCookie {
-		_ = godotenv.Load()
-		// These keys need to be *exactly* 16 or 32 bytes long.
-		var hashKey = []byte(os.Getenv(envCookieHashKey))[:32]
-		var blockKey = []byte(os.Getenv(envCookieEncKey))[:32]
-		return securecookie.New(hashKey, blockKey)
-	}()
-)
+// cookieCodecsFromEnv builds the codecs we use for encoding and decoding
+// cookies from the keys in the environment. The current keys are required. The
+// previous keys are optional but need to be set together.
+//
+// We encode cookies with the current keys and decode them with both the current
+// and the previous ones. This allows rotating the keys without logging out all
+// users: move the current keys to the previous ones and set new current ones.
+// Once all cookies encoded with the previous keys have expired, the previous
+// keys can be removed.
+func cookieCodecsFromEnv() ([]securecookie.Codec, error) {
+	return cookieCodecs(
+		os.Getenv(envCookieHashKey),
+		os.Getenv(envCookieEncKey),
+		os.Getenv(envCookiePrevHashKey),
+		os.Getenv(envCookiePrevEncKey),
+	)
+}
+
+// cookieCodecs builds the cookie codecs from the given keys. See
+// cookieCodecsFromEnv.
+func cookieCodecs(hashKey, encKey, prevHashKey, prevEncKey string) ([]securecookie.Codec, error) {
+	hk, err1 := cookieKey(envCookieHashKey, hashKey)
+	ek, err2 := cookieKey(envCookieEncKey, encKey)
+	if err := errors.Compose(err1, err2); err != nil {
+		return nil, err
+	}
+	pairs := [][]byte{hk, ek}
+	if prevHashKey != "" || prevEncKey != "" {
+		phk, err1 := cookieKey(envCookiePrevHashKey, prevHashKey)
+		pek, err2 := cookieKey(envCookiePrevEncKey, prevEncKey)
+		if err := errors.Compose(err1, err2); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, phk, pek)
+	}
+	return securecookie.CodecsFromPairs(pairs...), nil
+}
+
+// cookieKey validates the given key and truncates it to cookieKeyLen. The name
+// of the env var which holds the key is used for the error messages.
+func cookieKey(envVar, key string) ([]byte, error) {
+	if key == "" {
+		return nil, errors.New("missing env var " + envVar)
+	}
+	if len(key) < cookieKeyLen {
+		return nil, errors.New(fmt.Sprintf("env var %s needs to be at least %d bytes long, it is %d", envVar, cookieKeyLen, len(key)))
+	}
+	return []byte(key)[:cookieKeyLen], nil
+}
 
 // writeCookie is a helper function that writes the given JWT token as a
-// secure cookie.
+// secure cookie. When CSRF protection is enabled it also writes a new CSRF
+// token or, if the JWT token is empty, deletes it.
 // See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Set-Cookie
-func writeCookie(w http.ResponseWriter, token string, exp int64) error {
-	encodedValue, err := secureCookie.Encode(CookieName, token)
+func (api *API) writeCookie(w http.ResponseWriter, token string, exp int64) error {
+	encodedValue, err := securecookie.EncodeMulti(CookieName, token, api.staticCookieCodecs...)
 	if err != nil {
 		return err
 	}
@@ -58,5 +110,12 @@ func writeCookie(w http.ResponseWriter, token string, exp int64) error {
 		SameSite: 1,    // https://tools.ietf.org/html/draft-ietf-httpbis-cookie-same-site-00
 	}
 	http.SetCookie(w, cookie)
+	if CSRFProtection {
+		if token == "" {
+			writeCSRFCookie(w, "", -1)
+		} else {
+			writeCSRFCookie(w, newCSRFToken(), cookie.MaxAge)
+		}
+	}
 	return nil
 }
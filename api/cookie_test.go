@@ -0,0 +1,61 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+// TestCookieCodecs ensures cookieCodecs validates the keys and that cookies
+// encoded with the previous keys can still be decoded after a key rotation.
+func TestCookieCodecs(t *testing.T) {
+	oldHash, oldEnc := strings.Repeat("a", cookieKeyLen), strings.Repeat("b", cookieKeyLen)
+	newHash, newEnc := strings.Repeat("c", cookieKeyLen), strings.Repeat("d", cookieKeyLen)
+
+	// Invalid configurations.
+	tests := []struct {
+		name                               string
+		hashKey, encKey, prevHash, prevEnc string
+	}{
+		{name: "missing hash key", encKey: newEnc},
+		{name: "missing enc key", hashKey: newHash},
+		{name: "short key", hashKey: newHash, encKey: newEnc[:16]},
+		{name: "missing previous enc key", hashKey: newHash, encKey: newEnc, prevHash: oldHash},
+		{name: "short previous key", hashKey: newHash, encKey: newEnc, prevHash: oldHash, prevEnc: "short"},
+	}
+	for _, tt := range tests {
+		if _, err := cookieCodecs(tt.hashKey, tt.encKey, tt.prevHash, tt.prevEnc); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	// Encode a cookie with the old keys. Longer keys are truncated, so we
+	// expect the extra bytes to be ignored.
+	oldCodecs, err := cookieCodecs(oldHash+"extra", oldEnc, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := securecookie.EncodeMulti(CookieName, "token", oldCodecs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rotate the keys and make sure we can still decode the cookie.
+	codecs, err := cookieCodecs(newHash, newEnc, oldHash, oldEnc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	err = securecookie.DecodeMulti(CookieName, encoded, &value, codecs...)
+	if err != nil || value != "token" {
+		t.Fatalf("expected to decode the old cookie, got '%s', %v", value, err)
+	}
+	// New cookies are encoded with the new keys.
+	encoded, err = securecookie.EncodeMulti(CookieName, "token", codecs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = securecookie.DecodeMulti(CookieName, encoded, &value, oldCodecs...); err == nil {
+		t.Fatal("expected the new cookie not to be decodable with the old keys")
+	}
+}
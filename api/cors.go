@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// CORSAllowedOrigins lists the origins, e.g. "https://account.siasky.net",
+	// which are allowed to make cross-origin requests to the API.
+	// "https://*.siasky.net" allows all subdomains of siasky.net. "*" is not
+	// allowed, see ErrCORSWildcardOrigin. CORS is disabled when the list is
+	// empty. The point of this var is to be overridable via .env.
+	CORSAllowedOrigins []string
+	// CORSAllowedMethods lists the methods allowed in cross-origin requests.
+	// When empty, we allow all methods registered for the requested route.
+	// The point of this var is to be overridable via .env.
+	CORSAllowedMethods []string
+	// CORSAllowedHeaders lists the headers allowed in cross-origin requests.
+	// The point of this var is to be overridable via .env.
+	CORSAllowedHeaders = []string{"Authorization", "Content-Type", CSRFHeader, APIKeyHeader}
+
+	// corsMaxAge defines for how long browsers can cache the response to a
+	// preflight request.
+	corsMaxAge = 10 * time.Minute
+
+	// ErrCORSWildcardOrigin is returned when CORSAllowedOrigins contains "*".
+	// We allow credentialed cross-origin requests, so allowing all origins
+	// would let any website make requests with the user's cookie and read the
+	// responses.
+	ErrCORSWildcardOrigin = errors.New("allowing all origins is not supported because we allow credentialed requests")
+)
+
+// withCORS wraps the given handler with a CORS layer which allows credentialed
+// cross-origin requests from the CORSAllowedOrigins. We echo the request's
+// origin instead of using "*" because browsers don't send cookies to "*".
+//
+// See https://developer.mozilla.org/en-US/docs/Web/HTTP/CORS
+func withCORS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(CORSAllowedOrigins) > 0 {
+			// The response depends on the origin, so caches need to know.
+			w.Header().Add("Vary", "Origin")
+			if origin := req.Header.Get("Origin"); corsOriginAllowed(origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// corsPreflightHandler responds to CORS preflight requests. The router calls it
+// for OPTIONS requests to all registered routes, after setting the Allow
+// header to the methods registered for the route. withCORS has already set
+// the origin headers by then.
+func corsPreflightHandler(w http.ResponseWriter, req *http.Request) {
+	if w.Header().Get("Access-Control-Allow-Origin") == "" || req.Header.Get("Access-Control-Request-Method") == "" {
+		// This is either a regular OPTIONS request or a preflight request
+		// from an origin we don't allow. Either way, the Allow header is all
+		// the caller gets.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methods := w.Header().Get("Allow")
+	if len(CORSAllowedMethods) > 0 {
+		methods = strings.Join(CORSAllowedMethods, ", ")
+	}
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(CORSAllowedHeaders, ", "))
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateCORSOrigins ensures the given list of allowed origins doesn't allow
+// all origins.
+func validateCORSOrigins(origins []string) error {
+	for _, o := range origins {
+		if strings.TrimSpace(o) == "*" {
+			return ErrCORSWildcardOrigin
+		}
+	}
+	return nil
+}
+
+// corsOriginAllowed reports whether the given origin is one of the
+// CORSAllowedOrigins.
+func corsOriginAllowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range CORSAllowedOrigins {
+		o = strings.ToLower(o)
+		if o == origin {
+			return true
+		}
+		// Wildcard subdomains, e.g. "https://*.siasky.net".
+		if i := strings.Index(o, "://*."); i >= 0 {
+			scheme, domain := o[:i+3], o[i+4:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) && len(origin) > len(scheme)+len(domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestCORS ensures the CORS layer only allows the configured origins and
+// answers preflight requests.
+func TestCORS(t *testing.T) {
+	oldOrigins, oldMethods := CORSAllowedOrigins, CORSAllowedMethods
+	defer func() {
+		CORSAllowedOrigins, CORSAllowedMethods = oldOrigins, oldMethods
+	}()
+	CORSAllowedOrigins = []string{"https://siasky.net", "https://*.skynetpro.net"}
+	CORSAllowedMethods = nil
+
+	origins := map[string]bool{
+		"https://siasky.net":            true,
+		"https://SIASKY.net":            true,
+		"https://account.skynetpro.net": true,
+		"https://skynetpro.net":         false,
+		"http://account.skynetpro.net":  false,
+		"https://evilskynetpro.net":     false,
+		"https://evil.com":              false,
+		"":                              false,
+	}
+	for origin, allowed := range origins {
+		if corsOriginAllowed(origin) != allowed {
+			t.Errorf("expected origin '%s' to be allowed: %t", origin, allowed)
+		}
+	}
+
+	// Allowing all origins is rejected, because we allow credentialed
+	// requests. Even if it slips through, it doesn't allow anything.
+	if err := validateCORSOrigins(CORSAllowedOrigins); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateCORSOrigins([]string{"https://siasky.net", " * "}); !errors.Contains(err, ErrCORSWildcardOrigin) {
+		t.Fatalf("expected %v, got %v", ErrCORSWildcardOrigin, err)
+	}
+	CORSAllowedOrigins = []string{"*"}
+	if corsOriginAllowed("https://evil.com") {
+		t.Fatal("expected '*' not to allow any origin")
+	}
+	CORSAllowedOrigins = []string{"https://siasky.net", "https://*.skynetpro.net"}
+
+	// Regular requests get the origin headers.
+	h := withCORS(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Origin", "https://siasky.net")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://siasky.net" {
+		t.Fatalf("unexpected allowed origin '%s'", rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "true" || rec.Header().Get("Vary") != "Origin" {
+		t.Fatalf("unexpected headers %v", rec.Header())
+	}
+	req.Header.Set("Origin", "https://evil.com")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("expected no CORS headers for an unknown origin")
+	}
+
+	// Preflight requests get the methods registered for the route, which
+	// the router passes in the Allow header.
+	h = withCORS(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Allow", "GET, DELETE, OPTIONS")
+		corsPreflightHandler(w, req)
+	}))
+	req = httptest.NewRequest(http.MethodOptions, "/user", nil)
+	req.Header.Set("Origin", "https://siasky.net")
+	req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec.Header().Get("Access-Control-Allow-Methods") != "GET, DELETE, OPTIONS" {
+		t.Fatalf("unexpected allowed methods '%s'", rec.Header().Get("Access-Control-Allow-Methods"))
+	}
+	if rec.Header().Get("Access-Control-Allow-Headers") == "" || rec.Header().Get("Access-Control-Max-Age") == "" {
+		t.Fatalf("unexpected headers %v", rec.Header())
+	}
+}
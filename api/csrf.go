@@ -0,0 +1,102 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+const (
+	// CSRFCookieName is the name of the cookie which holds the CSRF token.
+	// Unlike the JWT cookie, it's readable by JavaScript, so the portal's
+	// frontend can send its value in the CSRFHeader header.
+	CSRFCookieName = "skynet-csrf"
+	// CSRFHeader is the name of the header in which the client needs to send
+	// the value of the CSRF cookie.
+	CSRFHeader = "X-CSRF-Token"
+
+	// csrfTokenEntropy is the number of random bytes in a CSRF token.
+	csrfTokenEntropy = 32
+)
+
+var (
+	// CSRFProtection defines whether we protect the state-changing requests
+	// which are authenticated with the JWT cookie against cross-site request
+	// forgery. The point of this var is to be overridable via .env.
+	CSRFProtection = false
+	// CSRFTrustedOrigins lists the origins, e.g. "https://siasky.net", from
+	// which we accept state-changing requests authenticated with the JWT
+	// cookie, even without a CSRF token. The point of this var is to be
+	// overridable via .env.
+	CSRFTrustedOrigins []string
+
+	// ErrCSRF is returned when a state-changing request authenticated with
+	// the JWT cookie has neither a valid CSRF token nor a trusted origin.
+	ErrCSRF = errors.New("missing or invalid CSRF token")
+)
+
+// checkCSRF ensures the given request, authenticated with the JWT cookie, was
+// not forged by another site. We use two independent checks and the request
+// needs to pass one of them. The first one is a double-submit token - the
+// client needs to send the value of the CSRF cookie in the CSRFHeader header.
+// Other sites can neither read the cookie nor set the header. The second one
+// is the request's Origin or, if that's missing, its Referer, which needs to be
+// one of the CSRFTrustedOrigins.
+//
+// Requests which don't change state and requests authenticated with the
+// Authorization header are not vulnerable to CSRF, so we don't check those.
+func checkCSRF(req *http.Request) error {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	if cookie, err := req.Cookie(CSRFCookieName); err == nil && cookie.Value != "" {
+		header := req.Header.Get(CSRFHeader)
+		if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1 {
+			return nil
+		}
+	}
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		if ref, err := url.Parse(req.Referer()); err == nil && ref.Host != "" {
+			origin = ref.Scheme + "://" + ref.Host
+		}
+	}
+	for _, o := range CSRFTrustedOrigins {
+		if origin != "" && strings.EqualFold(origin, o) {
+			return nil
+		}
+	}
+	return ErrCSRF
+}
+
+// writeCSRFCookie writes a new CSRF token as a cookie which expires together
+// with the JWT cookie. An empty token deletes the cookie.
+func writeCSRFCookie(w http.ResponseWriter, token string, maxAge int) {
+	domain, ok := os.LookupEnv(envCookieDomain)
+	if !ok {
+		domain = "127.0.0.1"
+	}
+	cookie := &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		HttpOnly: false, // the frontend needs to read it
+		Path:     "/",
+		Domain:   domain,
+		MaxAge:   maxAge,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	http.SetCookie(w, cookie)
+}
+
+// newCSRFToken returns a new random CSRF token.
+func newCSRFToken() string {
+	return hex.EncodeToString(fastrand.Bytes(csrfTokenEntropy))
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestCheckCSRF ensures checkCSRF accepts requests with a valid CSRF token or
+// a trusted origin and rejects all other state-changing requests.
+func TestCheckCSRF(t *testing.T) {
+	oldOrigins := CSRFTrustedOrigins
+	defer func() {
+		CSRFTrustedOrigins = oldOrigins
+	}()
+	CSRFTrustedOrigins = []string{"https://siasky.net"}
+
+	token := newCSRFToken()
+	tests := []struct {
+		name    string
+		method  string
+		cookie  string
+		header  string
+		origin  string
+		referer string
+		valid   bool
+	}{
+		{name: "GET", method: http.MethodGet, valid: true},
+		{name: "no token", method: http.MethodPost, valid: false},
+		{name: "valid token", method: http.MethodPost, cookie: token, header: token, valid: true},
+		{name: "wrong token", method: http.MethodPost, cookie: token, header: newCSRFToken(), valid: false},
+		{name: "header without cookie", method: http.MethodPost, header: token, valid: false},
+		{name: "trusted origin", method: http.MethodDelete, origin: "https://siasky.net", valid: true},
+		{name: "untrusted origin", method: http.MethodPost, origin: "https://evil.com", valid: false},
+		{name: "trusted referer", method: http.MethodPost, referer: "https://siasky.net/account", valid: true},
+		{name: "untrusted referer", method: http.MethodPost, referer: "https://evil.com/siasky.net", valid: false},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "/track/upload/skylink", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookie})
+		}
+		if tt.header != "" {
+			req.Header.Set(CSRFHeader, tt.header)
+		}
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.referer != "" {
+			req.Header.Set("Referer", tt.referer)
+		}
+		err = checkCSRF(req)
+		if tt.valid && err != nil {
+			t.Errorf("%s: expected the request to be valid, got %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected the request to be invalid", tt.name)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"crypto/ed25519"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA implements the EdDSA signing method with Ed25519 keys.
+// The JWT library we use doesn't support it out of the box.
+// See https://tools.ietf.org/html/rfc8037
+var SigningMethodEdDSA = &signingMethodEd25519{}
+
+// signingMethodEd25519 implements jwt.SigningMethod for Ed25519 keys.
+type signingMethodEd25519 struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// Alg returns the name of the signing method, as used in the `alg` header.
+func (m *signingMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+// Verify verifies the signature of the signing string with the given
+// ed25519.PublicKey.
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	pk, ok := key.(ed25519.PublicKey)
+	if !ok || len(pk) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pk, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Sign signs the signing string with the given ed25519.PrivateKey.
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	sk, ok := key.(ed25519.PrivateKey)
+	if !ok || len(sk) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(sk, []byte(signingString))), nil
+}
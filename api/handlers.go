@@ -1,6 +1,8 @@
 package api
 
 import (
+	"archive/zip"
+	"context"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -9,31 +11,79 @@ import (
 	"github.com/NebulousLabs/skynet-accounts/database"
 	"github.com/NebulousLabs/skynet-accounts/metafetcher"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/julienschmidt/httprouter"
 	"gitlab.com/NebulousLabs/errors"
 )
 
+// userGETResponse is the response to GET /user. It contains the user's data
+// together with the limits of their tier and their current usage.
+type userGETResponse struct {
+	*database.User
+	Limits database.TierLimits `json:"limits"`
+	Stats  *database.UserStats `json:"stats"`
+}
+
 // loginHandler starts a user session by issuing a cookie
 func (api *API) loginHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	tokenStr, err := tokenFromRequest(req)
+	tokenStr, _, err := api.tokenFromRequest(req)
 	if err != nil {
 		api.staticLogger.Traceln("Error fetching token from request:", err)
 		api.WriteError(w, err, http.StatusUnauthorized)
 		return
 	}
-	token, err := ValidateToken(api.staticLogger, tokenStr)
+	token, err := ValidateToken(api.staticAuth, tokenStr)
 	if err != nil {
 		api.staticLogger.Traceln("Error validating token:", err)
 		api.WriteError(w, err, http.StatusUnauthorized)
 		return
 	}
+	revoked, err := api.tokenRevoked(req.Context(), token)
+	if err != nil {
+		api.staticLogger.Traceln("Error checking token revocation:", err)
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if revoked {
+		api.WriteError(w, ErrTokenRevoked, http.StatusUnauthorized)
+		return
+	}
+	revoked, err = api.sessionRevoked(req.Context(), token)
+	if err != nil {
+		api.staticLogger.Traceln("Error checking session revocation:", err)
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if revoked {
+		api.WriteError(w, ErrSessionRevoked, http.StatusUnauthorized)
+		return
+	}
 	exp, err := tokenExpiration(token)
 	if err != nil {
 		api.staticLogger.Traceln("Error checking token expiration:", err)
 		api.WriteError(w, err, http.StatusUnauthorized)
 		return
 	}
-	err = writeCookie(w, tokenStr, exp)
+	claims := token.Claims.(jwt.MapClaims)
+	sub, ok := claims["sub"].(string)
+	if !ok {
+		api.WriteError(w, errors.New("jwt claims don't contain a valid sub"), http.StatusUnauthorized)
+		return
+	}
+	u, err := api.upsertUser(req.Context(), sub, claims, true)
+	if err != nil {
+		api.staticLogger.Traceln("Error updating user:", err)
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	err = api.bootstrapAdmin(req.Context(), u)
+	if err != nil {
+		api.staticLogger.Traceln("Error bootstrapping admin:", err)
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.recordSession(req, token, true)
+	err = api.writeCookie(w, tokenStr, exp)
 	if err != nil {
 		api.staticLogger.Traceln("Error writing cookie:", err)
 		api.WriteError(w, err, http.StatusInternalServerError)
@@ -42,41 +92,102 @@ func (api *API) loginHandler(w http.ResponseWriter, req *http.Request, _ httprou
 	api.WriteSuccess(w)
 }
 
-// logoutHandler ends a user session by removing a cookie
+// logoutHandler ends a user session by revoking its token and removing the
+// cookie. We remove the cookie first, so the user is logged out of the
+// browser even if we fail to revoke the token.
 func (api *API) logoutHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	_, _, _, err := tokenFromContext(req)
+	_, _, token, err := tokenFromContext(req)
 	if err != nil {
 		api.staticLogger.Traceln("Error fetching token from context:", err)
 		api.WriteError(w, err, http.StatusUnauthorized)
 		return
 	}
-	err = writeCookie(w, "", time.Now().UTC().Unix()-1)
+	err = api.writeCookie(w, "", time.Now().UTC().Unix()-1)
 	if err != nil {
 		api.staticLogger.Traceln("Error deleting cookie:", err)
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
+	err = api.revokeToken(req.Context(), token)
+	if err != nil {
+		api.staticLogger.Traceln("Error revoking token:", err)
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
 	api.WriteSuccess(w)
 }
 
 // userHandler returns information about an existing user and create it if it
-// doesn't exist.
+// doesn't exist. It also updates the user's identity traits from the token.
 func (api *API) userHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	sub, _, _, err := tokenFromContext(req)
+	sub, claims, _, err := tokenFromContext(req)
 	if err != nil {
 		api.WriteError(w, err, http.StatusUnauthorized)
 		return
 	}
-	u, err := api.staticDB.UserBySub(req.Context(), sub, true)
+	u, err := api.upsertUser(req.Context(), sub, claims, false)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	stats, err := api.staticDB.UserStats(req.Context(), *u)
 	if err != nil {
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
-	api.WriteJSON(w, u)
+	limits, ok := database.LimitsForTier(u.Tier)
+	if !ok {
+		api.WriteError(w, errors.New("user has an invalid tier"), http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, userGETResponse{
+		User:   u,
+		Limits: limits,
+		Stats:  stats,
+	})
 }
 
-// userStatsHandler returns statistics about an existing user.
-func (api *API) userStatsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+// limitsHandler returns the limits of all user tiers.
+func (api *API) limitsHandler(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	api.WriteJSON(w, database.UserLimits[database.TierFree:])
+}
+
+// userDELETEHandler deletes the current user together with all of their data
+// and ends their session.
+func (api *API) userDELETEHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sub, _, token, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, false)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	err = api.staticDB.UserDelete(req.Context(), u)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// The user is gone, so failing to end their session is not an error. At
+	// worst, their next request will create a new, empty account.
+	if err = api.revokeToken(req.Context(), token); err != nil {
+		api.staticLogger.Traceln("Error revoking token:", err)
+	}
+	if err = api.writeCookie(w, "", time.Now().UTC().Unix()-1); err != nil {
+		api.staticLogger.Traceln("Error deleting cookie:", err)
+	}
+	api.WriteSuccess(w)
+}
+
+// userExportHandler streams a zip archive which contains all data we hold
+// about the current user.
+func (api *API) userExportHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	sub, _, _, err := tokenFromContext(req)
 	if err != nil {
 		api.WriteError(w, err, http.StatusUnauthorized)
@@ -91,12 +202,132 @@ func (api *API) userStatsHandler(w http.ResponseWriter, req *http.Request, _ htt
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
-	ud, err := api.staticDB.UserStats(req.Context(), *u)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="skynet-account-export.zip"`)
+	// Once we start streaming we can no longer change the status code, so
+	// errors can only be logged. They result in a truncated, invalid archive.
+	zw := zip.NewWriter(w)
+	err = api.staticDB.UserExport(req.Context(), *u, zw)
+	if err != nil {
+		api.staticLogger.Debugln("Error exporting user data:", err)
+		return
+	}
+	if err = zw.Close(); err != nil {
+		api.staticLogger.Debugln("Error finalising user data export:", err)
+	}
+}
+
+// upsertUser fetches the user with the given sub, creating them if they don't
+// exist, and updates their identity traits from the given claims. Claims
+// without an identity, e.g. those we embed for API keys, leave the user's
+// traits unchanged. When login is true we also record the user's last login.
+func (api *API) upsertUser(ctx context.Context, sub string, claims jwt.MapClaims, login bool) (*database.User, error) {
+	id, ok := identityFromClaims(claims)
+	if !ok {
+		return api.staticDB.UserBySub(ctx, sub, true)
+	}
+	return api.staticDB.UserUpsertIdentity(ctx, sub, id, login)
+}
+
+// userStatsHandler returns statistics about an existing user.
+func (api *API) userStatsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	u, period, ok := api.statsUserAndPeriod(w, req)
+	if !ok {
+		return
+	}
+	api.writeStats(w, req, *u, period)
+}
+
+// writeStats responds with the given user's statistics for the given window.
+func (api *API) writeStats(w http.ResponseWriter, req *http.Request, u database.User, period database.BillingPeriod) {
+	stats, err := api.staticDB.UserStatsForPeriod(req.Context(), u, period)
+	if errors.Contains(err, database.ErrInvalidPeriod) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, stats)
+}
+
+// userStatsDailyHandler returns the current user's usage per day. It takes
+// the same params as userStatsHandler.
+func (api *API) userStatsDailyHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	u, period, ok := api.statsUserAndPeriod(w, req)
+	if !ok {
+		return
+	}
+	api.writeUsageSeries(w, req, *u, period, database.UsageBucketDay)
+}
+
+// userStatsHourlyHandler returns the current user's usage per hour. It takes
+// the same params as userStatsHandler.
+func (api *API) userStatsHourlyHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	u, period, ok := api.statsUserAndPeriod(w, req)
+	if !ok {
+		return
+	}
+	api.writeUsageSeries(w, req, *u, period, database.UsageBucketHour)
+}
+
+// writeUsageSeries responds with the given user's usage in the given window,
+// split into buckets of the given size.
+func (api *API) writeUsageSeries(w http.ResponseWriter, req *http.Request, u database.User, period database.BillingPeriod, bucket string) {
+	series, err := api.staticDB.UserUsageSeries(req.Context(), u, period, bucket)
+	if errors.Contains(err, database.ErrInvalidPeriod) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
-	api.WriteJSON(w, ud)
+	api.WriteJSON(w, series)
+}
+
+// statsUserAndPeriod fetches the current user and the window for which they
+// requested their statistics, see statsPeriod. If that fails it writes the
+// error to the response and returns false.
+func (api *API) statsUserAndPeriod(w http.ResponseWriter, req *http.Request) (*database.User, database.BillingPeriod, bool) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return nil, database.BillingPeriod{}, false
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, false)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return nil, database.BillingPeriod{}, false
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return nil, database.BillingPeriod{}, false
+	}
+	period, ok := api.statsPeriod(w, req, *u)
+	if !ok {
+		return nil, database.BillingPeriod{}, false
+	}
+	return u, period, true
+}
+
+// statsPeriod returns the window for which the given user's statistics are
+// requested either via the `period` parameter, which counts billing periods
+// back from the current one, or via the `from` and `to` parameters. It
+// defaults to the current billing period. If the parameters are invalid it
+// writes the error to the response and returns false.
+func (api *API) statsPeriod(w http.ResponseWriter, req *http.Request, u database.User) (database.BillingPeriod, bool) {
+	if err := req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return database.BillingPeriod{}, false
+	}
+	period, err := fetchStatsPeriod(req.Form, u.SubscribedUntil, time.Now())
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return database.BillingPeriod{}, false
+	}
+	return period, true
 }
 
 // userUploadsHandler returns all uploads made by the current user.
@@ -111,17 +342,26 @@ func (api *API) userUploadsHandler(w http.ResponseWriter, req *http.Request, _ h
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
-	if err = req.ParseForm(); err != nil {
+	api.writeUploads(w, req, *u)
+}
+
+// writeUploads responds with the page of uploads made by the given user which is
+// requested via the `offset` and `pageSize` parameters.
+func (api *API) writeUploads(w http.ResponseWriter, req *http.Request, u database.User) {
+	if err := req.ParseForm(); err != nil {
 		api.WriteError(w, err, http.StatusBadRequest)
+		return
 	}
 	offset, err1 := fetchOffset(req.Form)
 	pageSize, err2 := fetchPageSize(req.Form)
-	if err = errors.Compose(err1, err2); err != nil {
+	if err := errors.Compose(err1, err2); err != nil {
 		api.WriteError(w, err, http.StatusBadRequest)
+		return
 	}
-	ups, total, err := api.staticDB.UploadsByUser(req.Context(), *u, offset, pageSize)
+	ups, total, err := api.staticDB.UploadsByUser(req.Context(), u, offset, pageSize)
 	if err != nil {
 		api.WriteError(w, err, http.StatusInternalServerError)
+		return
 	}
 	response := database.UploadsResponseDTO{
 		Items:    ups,
@@ -144,17 +384,26 @@ func (api *API) userDownloadsHandler(w http.ResponseWriter, req *http.Request, _
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
-	if err = req.ParseForm(); err != nil {
+	api.writeDownloads(w, req, *u)
+}
+
+// writeDownloads responds with the page of downloads made by the given user which is
+// requested via the `offset` and `pageSize` parameters.
+func (api *API) writeDownloads(w http.ResponseWriter, req *http.Request, u database.User) {
+	if err := req.ParseForm(); err != nil {
 		api.WriteError(w, err, http.StatusBadRequest)
+		return
 	}
 	offset, err1 := fetchOffset(req.Form)
 	pageSize, err2 := fetchPageSize(req.Form)
-	if err = errors.Compose(err1, err2); err != nil {
+	if err := errors.Compose(err1, err2); err != nil {
 		api.WriteError(w, err, http.StatusBadRequest)
+		return
 	}
-	downs, total, err := api.staticDB.DownloadsByUser(req.Context(), *u, offset, pageSize)
+	downs, total, err := api.staticDB.DownloadsByUser(req.Context(), u, offset, pageSize)
 	if err != nil {
 		api.WriteError(w, err, http.StatusInternalServerError)
+		return
 	}
 	response := database.DownloadsResponseDTO{
 		Items:    downs,
@@ -206,6 +455,7 @@ func (api *API) trackUploadHandler(w http.ResponseWriter, req *http.Request, ps
 			}
 		}()
 	}
+	api.checkUsage(sub, *u)
 	api.WriteSuccess(w)
 }
 
@@ -270,6 +520,7 @@ func (api *API) trackDownloadHandler(w http.ResponseWriter, req *http.Request, p
 			}
 		}()
 	}
+	api.checkUsage(sub, *u)
 	api.WriteSuccess(w)
 }
 
@@ -322,6 +573,44 @@ func fetchOffset(form url.Values) (int, error) {
 	return offset, nil
 }
 
+// fetchStatsPeriod extracts the window for which we report a user's
+// statistics from the params. `period` is the number of billing periods before
+// the current one, up to database.MaxStatsPeriodsAgo. `from` and `to` are
+// RFC3339 timestamps, where `to` defaults to now. The length of the window is
+// validated by the database.
+func fetchStatsPeriod(form url.Values, subscribedUntil, now time.Time) (database.BillingPeriod, error) {
+	period, from, to := form.Get("period"), form.Get("from"), form.Get("to")
+	if from == "" && to == "" {
+		n := 0
+		if period != "" {
+			var err error
+			n, err = strconv.Atoi(period)
+			if err != nil || n < 0 || n > database.MaxStatsPeriodsAgo {
+				return database.BillingPeriod{}, errors.New("invalid parameter 'period'")
+			}
+		}
+		return database.BillingPeriodsAgo(subscribedUntil, now, n), nil
+	}
+	if period != "" {
+		return database.BillingPeriod{}, errors.New("parameter 'period' can't be combined with 'from' and 'to'")
+	}
+	if from == "" {
+		return database.BillingPeriod{}, errors.New("missing parameter 'from'")
+	}
+	start, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return database.BillingPeriod{}, errors.AddContext(err, "invalid parameter 'from'")
+	}
+	end := now
+	if to != "" {
+		end, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return database.BillingPeriod{}, errors.AddContext(err, "invalid parameter 'to'")
+		}
+	}
+	return database.BillingPeriod{Start: start, End: end}, nil
+}
+
 // fetchPageSize extracts the page size from the params and validates its value.
 func fetchPageSize(form url.Values) (int, error) {
 	pageSize, _ := strconv.Atoi(form.Get("pageSize"))
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+)
+
+// TestLogoutHandlerWithoutJTI ensures that logging out with a token which
+// doesn't have a `jti` succeeds and removes the cookie, even though there is
+// nothing to revoke.
+func TestLogoutHandlerWithoutJTI(t *testing.T) {
+	codecs, err := cookieCodecs(strings.Repeat("a", cookieKeyLen), strings.Repeat("b", cookieKeyLen), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &API{
+		staticLogger:       logrus.New(),
+		staticCookieCodecs: codecs,
+	}
+	token := &jwt.Token{
+		Claims: jwt.MapClaims{
+			"sub": "a-sub",
+			"exp": float64(time.Now().Add(time.Hour).Unix()),
+		},
+		Valid: true,
+	}
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ctxValue("token"), token))
+	w := httptest.NewRecorder()
+	api.logoutHandler(w, req, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	var removed bool
+	for _, c := range w.Result().Cookies() {
+		if c.Name == CookieName && c.MaxAge < 0 {
+			removed = true
+		}
+	}
+	if !removed {
+		t.Fatal("Expected the cookie to be removed.")
+	}
+}
+
+// TestFetchStatsPeriod ensures we correctly parse the window for which we
+// report a user's statistics.
+func TestFetchStatsPeriod(t *testing.T) {
+	subscribedUntil := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2021, 3, 20, 12, 0, 0, 0, time.UTC)
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		form   url.Values
+		period database.BillingPeriod
+		valid  bool
+	}{
+		{name: "default", form: url.Values{}, period: database.BillingPeriodAt(subscribedUntil, now), valid: true},
+		{name: "current", form: url.Values{"period": {"0"}}, period: database.BillingPeriodAt(subscribedUntil, now), valid: true},
+		{name: "previous", form: url.Values{"period": {"1"}}, period: database.BillingPeriodsAgo(subscribedUntil, now, 1), valid: true},
+		{name: "oldest", form: url.Values{"period": {"12"}}, period: database.BillingPeriodsAgo(subscribedUntil, now, 12), valid: true},
+		{name: "too old", form: url.Values{"period": {"13"}}, valid: false},
+		{name: "negative", form: url.Values{"period": {"-1"}}, valid: false},
+		{name: "not a number", form: url.Values{"period": {"last"}}, valid: false},
+		{name: "from and to", form: url.Values{"from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}}, period: database.BillingPeriod{Start: from, End: to}, valid: true},
+		{name: "from until now", form: url.Values{"from": {from.Format(time.RFC3339)}}, period: database.BillingPeriod{Start: from, End: now}, valid: true},
+		{name: "only to", form: url.Values{"to": {to.Format(time.RFC3339)}}, valid: false},
+		{name: "invalid from", form: url.Values{"from": {"yesterday"}}, valid: false},
+		{name: "period and from", form: url.Values{"period": {"1"}, "from": {from.Format(time.RFC3339)}}, valid: false},
+	}
+	for _, tt := range tests {
+		period, err := fetchStatsPeriod(tt.form, subscribedUntil, now)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %t, got error %v", tt.name, tt.valid, err)
+			continue
+		}
+		if tt.valid && (!period.Start.Equal(tt.period.Start) || !period.End.Equal(tt.period.End)) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.period, period)
+		}
+	}
+}
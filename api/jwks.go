@@ -0,0 +1,160 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-jwx/jwk"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// JWKSTTL defines how long we consider a fetched JWKS to be fresh. Once
+	// that time passes we fetch the JWKS again. The background refresher also
+	// uses this interval, so under normal operation requests never have to
+	// wait for the keys to be fetched. The point of this var is to be
+	// overridable via .env.
+	JWKSTTL = 15 * time.Minute
+
+	// jwksMinRefetchInterval is the minimum amount of time between two
+	// forced refetches of the JWKS. We force a refetch when we see a token
+	// with an unknown `kid` because that usually means that the issuer has
+	// rotated its keys. The limit prevents malicious tokens with random `kid`
+	// values from making us hammer the issuer with requests.
+	jwksMinRefetchInterval = 30 * time.Second
+
+	// jwksFetchTimeout is the maximum amount of time we are willing to wait
+	// for a JWKS to be served.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// jwksCache is a thread-safe cache of the JWKS served on a given URL, e.g. by
+// Oathkeeper. It refreshes the keys once they become older than JWKSTTL and
+// allows forcing a refresh, rate-limited by jwksMinRefetchInterval, in order
+// to support key rotation.
+type jwksCache struct {
+	// staticURL is the URL on which the JWKS is served.
+	staticURL string
+
+	// keys is the most recently fetched key set.
+	keys *jwk.Set
+	// lastFetch is the time of the last successful fetch.
+	lastFetch time.Time
+	// lastAttempt is the time of the last fetch attempt, successful or not.
+	lastAttempt time.Time
+
+	// fetchMu ensures only one goroutine fetches the keys at any given time.
+	fetchMu sync.Mutex
+	mu      sync.RWMutex
+}
+
+// managedKeys returns the cached key set. If there is no cached key set or it
+// is older than JWKSTTL, it fetches a fresh one.
+func (c *jwksCache) managedKeys(logger *logrus.Logger) (*jwk.Set, error) {
+	c.mu.RLock()
+	keys, lastFetch := c.keys, c.lastFetch
+	c.mu.RUnlock()
+	if keys != nil && time.Since(lastFetch) < JWKSTTL {
+		return keys, nil
+	}
+	return c.managedRefresh(logger, false)
+}
+
+// managedRefresh fetches a fresh key set and caches it.
+//
+// When force is false we skip the fetch if another goroutine refreshed the
+// keys while we were waiting for our turn. When force is true we skip the
+// fetch if the last attempt was made less than jwksMinRefetchInterval ago.
+//
+// If the fetch fails but we have a previously fetched key set we log the
+// error and keep using the old keys. This way a short outage of the issuer
+// does not log everybody out.
+func (c *jwksCache) managedRefresh(logger *logrus.Logger, force bool) (*jwk.Set, error) {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	c.mu.RLock()
+	keys, lastFetch, lastAttempt := c.keys, c.lastFetch, c.lastAttempt
+	c.mu.RUnlock()
+	if !force && keys != nil && time.Since(lastFetch) < JWKSTTL {
+		return keys, nil
+	}
+	if force && time.Since(lastAttempt) < jwksMinRefetchInterval {
+		logger.Traceln("skipping forced JWKS refetch, the last one was at", lastAttempt)
+		if keys == nil {
+			return nil, errors.New("no JWKS available")
+		}
+		return keys, nil
+	}
+
+	c.mu.Lock()
+	c.lastAttempt = time.Now().UTC()
+	c.mu.Unlock()
+
+	set, err := fetchJWKS(logger, c.staticURL)
+	if err != nil {
+		if keys != nil {
+			logger.Warningln("failed to refresh JWKS, using the cached one:", err)
+			return keys, nil
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.keys = set
+	c.lastFetch = time.Now().UTC()
+	c.mu.Unlock()
+	return set, nil
+}
+
+// threadedRefresh periodically refreshes the cached keys, so they are always
+// fresh when a request needs them.
+func (c *jwksCache) threadedRefresh(logger *logrus.Logger) {
+	for {
+		_, err := c.managedRefresh(logger, false)
+		if err != nil {
+			logger.Warningln("background JWKS refresh failed:", err)
+		}
+		// Sleep for a bit less than the TTL, so the keys don't expire
+		// between two refreshes.
+		time.Sleep(JWKSTTL * 9 / 10)
+	}
+}
+
+// fetchJWKS fetches and parses the JWKS served on the given URL.
+//
+// See https://tools.ietf.org/html/rfc7517
+// See https://auth0.com/blog/navigating-rs256-and-jwks/
+// See http://self-issued.info/docs/draft-ietf-oauth-json-web-token.html
+// Encoding RSA pub key: https://play.golang.org/p/mLpOxS-5Fy
+func fetchJWKS(logger *logrus.Logger, jwksURL string) (*jwk.Set, error) {
+	logger.Traceln("fetching JWKS:", jwksURL)
+	client := http.Client{Timeout: jwksFetchTimeout}
+	r, err := client.Get(jwksURL) // #nosec G107: Potential HTTP request made with variable url
+	if err != nil {
+		logger.Warningln("ERROR while fetching JWKS", err)
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		err = errors.New(fmt.Sprintf("unexpected status code %d while fetching JWKS", r.StatusCode))
+		logger.Warningln("ERROR while fetching JWKS", err)
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		logger.Warningln("ERROR while reading JWKS", err)
+		return nil, err
+	}
+	set, err := jwk.ParseString(string(b))
+	if err != nil {
+		logger.Warningln("ERROR while parsing JWKS", err)
+		logger.Warningln("JWKS string:", string(b))
+		return nil, err
+	}
+	return set, nil
+}
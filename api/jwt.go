@@ -2,33 +2,67 @@ package api
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"reflect"
 	"strings"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/lestrrat/go-jwx/jwk"
-	"github.com/sirupsen/logrus"
+	"github.com/gorilla/securecookie"
 	"gitlab.com/NebulousLabs/errors"
 )
 
 var (
-	// oathkeeperPubKeys is the public RS key exposed by Oathkeeper for JWT
-	// validation. It's available at oathkeeperPubKeyURL.
-	oathkeeperPubKeys *jwk.Set = nil
-
 	// KratosAddr holds the domain + port on which we can find Kratos.
 	// The point of this var is to be overridable via .env.
 	KratosAddr = "kratos:4433"
 
 	// OathkeeperAddr holds the domain + port on which we can find Oathkeeper.
+	// We use Oathkeeper's JWKS when API.New is not given an Authenticator.
 	// The point of this var is to be overridable via .env.
 	OathkeeperAddr = "oathkeeper:4456"
+
+	// JWTIssuer is the expected value of the `iss` claim. If it's empty we
+	// don't verify the issuer. The point of this var is to be overridable via
+	// .env.
+	JWTIssuer = ""
+
+	// JWTAudience is the expected value of the `aud` claim. If it's empty we
+	// don't verify the audience. The point of this var is to be overridable
+	// via .env.
+	JWTAudience = ""
+
+	// JWTClockSkew is the amount of clock skew we tolerate when verifying the
+	// time-based claims of a token. The point of this var is to be
+	// overridable via .env.
+	JWTClockSkew = 30 * time.Second
+
+	// ErrTokenExpired is returned when the token's `exp` is in the past.
+	ErrTokenExpired = errors.New("token is expired")
+	// ErrTokenNotValidYet is returned when the token's `nbf` is in the future.
+	ErrTokenNotValidYet = errors.New("token is not valid yet")
+	// ErrTokenIssuedInFuture is returned when the token's `iat` is in the
+	// future.
+	ErrTokenIssuedInFuture = errors.New("token is issued in the future")
+	// ErrTokenInvalidIssuer is returned when the token's `iss` doesn't match
+	// JWTIssuer.
+	ErrTokenInvalidIssuer = errors.New("token has an invalid issuer")
+	// ErrTokenInvalidAudience is returned when the token's `aud` doesn't
+	// contain JWTAudience.
+	ErrTokenInvalidAudience = errors.New("token has an invalid audience")
+	// ErrSessionInactive is returned when the Kratos session embedded in the
+	// token is not active.
+	ErrSessionInactive = errors.New("session is not active")
+	// ErrSessionExpired is returned when the Kratos session embedded in the
+	// token has expired.
+	ErrSessionExpired = errors.New("session is expired")
 )
 
 // ValidateToken verifies the validity of a JWT token, both in terms of validity
-// of the signature and expiration time.
+// of the signature and its claims. See validateClaims for the list of claims
+// we verify.
 //
 // Example token:
 //
@@ -86,99 +120,164 @@ var (
 //  },
 //  "sub": "695725d4-a345-4e68-919a-7395cb68484c"
 //}
-func ValidateToken(logger *logrus.Logger, t string) (*jwt.Token, error) {
-	keyForTokenWithLogger := func(token *jwt.Token) (interface{}, error) {
-		return keyForToken(logger, token)
+func ValidateToken(auth Authenticator, t string) (*jwt.Token, error) {
+	keyForTokenWithAuth := func(token *jwt.Token) (interface{}, error) {
+		return keyForToken(auth, token)
 	}
-	token, err := jwt.Parse(t, keyForTokenWithLogger)
+	// We validate the claims ourselves because we want to tolerate some
+	// clock skew and to verify a few additional claims.
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(t, keyForTokenWithAuth)
 	if err != nil {
 		return nil, err
 	}
 	if !token.Valid {
 		return nil, errors.New("token is invalid")
 	}
-	// TODO Verify issuer, scope, etc.?
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("the token does not contain the claims we expect")
+	}
+	err = validateClaims(claims, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
 	return token, nil
 }
 
-// keyForToken finds a suitable key for validating the
-// given token among the public keys provided by Oathkeeper.
-func keyForToken(logger *logrus.Logger, token *jwt.Token) (interface{}, error) {
-	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-		return nil, errors.New(fmt.Sprintf("unexpected signing method: %v", token.Header["alg"]))
+// validateClaims verifies the time-based claims of the token (`exp`, `nbf`
+// and `iat`), its issuer and audience (if JWTIssuer and JWTAudience are set),
+// and the Kratos session embedded in it. Each failure is reported with a
+// distinct error.
+func validateClaims(claims jwt.MapClaims, now time.Time) error {
+	exp, ok, err := numericClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("the token does not have an expiration time")
+	}
+	if now.Add(-JWTClockSkew).Unix() >= exp {
+		return ErrTokenExpired
 	}
-	keySet, err := oathkeeperPublicKeys(logger)
+	nbf, ok, err := numericClaim(claims, "nbf")
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if ok && now.Add(JWTClockSkew).Unix() < nbf {
+		return ErrTokenNotValidYet
+	}
+	iat, ok, err := numericClaim(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(JWTClockSkew).Unix() < iat {
+		return ErrTokenIssuedInFuture
 	}
-	if reflect.ValueOf(token.Header["kid"]).Kind() != reflect.String {
-		return nil, errors.New("invalid jwk header - the kid field is not a string")
+	if JWTIssuer != "" {
+		iss, _ := claims["iss"].(string)
+		if iss != JWTIssuer {
+			return ErrTokenInvalidIssuer
+		}
 	}
-	keys := keySet.LookupKeyID(token.Header["kid"].(string))
-	if len(keys) == 0 {
-		return nil, errors.New("no suitable keys found")
+	if JWTAudience != "" && !containsAudience(claims["aud"], JWTAudience) {
+		return ErrTokenInvalidAudience
 	}
-	return keys[0].Materialize()
+	return validateSession(claims, now)
 }
 
-// oathkeeperPublicKeys checks whether we have the
-// needed public key cached and if we don't it fetches it and caches it for us.
-//
-// See https://tools.ietf.org/html/rfc7517
-// See https://auth0.com/blog/navigating-rs256-and-jwks/
-// See http://self-issued.info/docs/draft-ietf-oauth-json-web-token.html
-// Encoding RSA pub key: https://play.golang.org/p/mLpOxS-5Fy
-func oathkeeperPublicKeys(logger *logrus.Logger) (*jwk.Set, error) {
-	if oathkeeperPubKeys == nil {
-		oathkeeperPubKeyURL := "http://" + OathkeeperAddr + "/.well-known/jwks.json"
-		logger.Traceln("fetching JWKS from oathkeeper:", oathkeeperPubKeyURL)
-		r, err := http.Get(oathkeeperPubKeyURL) // #nosec G107: Potential HTTP request made with variable url
-		if err != nil {
-			logger.Warningln("ERROR while fetching JWKS from oathkeeper", err)
-			return nil, err
-		}
-		defer r.Body.Close()
-		var b []byte
-		b, err = ioutil.ReadAll(r.Body)
-		if err != nil {
-			logger.Warningln("ERROR while reading JWKS from oathkeeper", err)
-			return nil, err
-		}
-		var set *jwk.Set
-		set, err = jwk.ParseString(string(b))
-		if err != nil {
-			logger.Warningln("ERROR while parsing JWKS from oathkeeper", err)
-			logger.Warningln("JWKS string:", string(b))
-			return nil, err
+// validateSession verifies that the Kratos session embedded in the token is
+// active and hasn't expired.
+func validateSession(claims jwt.MapClaims, now time.Time) error {
+	session, ok := claims["session"].(map[string]interface{})
+	if !ok {
+		return errors.New("the token does not contain a session")
+	}
+	if active, ok := session["active"].(bool); !ok || !active {
+		return ErrSessionInactive
+	}
+	expStr, ok := session["expires_at"].(string)
+	if !ok {
+		return errors.New("the token's session does not have an expiration time")
+	}
+	exp, err := time.Parse(time.RFC3339Nano, expStr)
+	if err != nil {
+		return errors.AddContext(err, "invalid session expiration time")
+	}
+	if !now.Add(-JWTClockSkew).Before(exp) {
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// numericClaim returns the value of the given numeric claim. The boolean
+// result reports whether the claim exists.
+func numericClaim(claims jwt.MapClaims, name string) (int64, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return 0, false, nil
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, false, errors.New(fmt.Sprintf("the token's %s claim is not a number", name))
+	}
+	return int64(f), true, nil
+}
+
+// containsAudience checks whether the `aud` claim contains the given audience.
+// The claim can be either a single string or an array of strings.
+func containsAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == audience {
+				return true
+			}
 		}
-		oathkeeperPubKeys = set
 	}
-	return oathkeeperPubKeys, nil
+	return false
+}
+
+// keyForToken ensures the token is signed with one of the signing methods we
+// support and asks the authenticator for a suitable key for validating it. We
+// support RSA (RS* and PS*), ECDSA (ES*) and Ed25519 (EdDSA) signatures.
+// Symmetric signing methods are not allowed because they would allow anybody
+// who knows the key to issue tokens.
+func keyForToken(auth Authenticator, token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *signingMethodEd25519:
+	default:
+		return nil, errors.New(fmt.Sprintf("unexpected signing method: %v", token.Header["alg"]))
+	}
+	return auth.Key(token)
 }
 
 // tokenFromRequest extracts the JWT token from the request and returns it.
-// It first checks the request headers and then the cookies.
-func tokenFromRequest(r *http.Request) (string, error) {
+// It first checks the request headers and then the cookies. It also reports
+// whether the token came from the cookie.
+func (api *API) tokenFromRequest(r *http.Request) (token string, fromCookie bool, err error) {
 	// Check the headers for a token.
 	authHeader := r.Header.Get("Authorization")
 	parts := strings.Split(authHeader, "Bearer")
 	if len(parts) == 2 {
-		return strings.TrimSpace(parts[1]), nil
+		return strings.TrimSpace(parts[1]), false, nil
 	}
 	// Check the cookie for a token.
 	cookie, err := r.Cookie(CookieName)
 	if errors.Contains(err, http.ErrNoCookie) {
-		return "", errors.New("no cookie found")
+		return "", false, errors.New("no cookie found")
 	}
 	if err != nil {
-		return "", errors.AddContext(err, "cookie exists but it's not valid")
+		return "", false, errors.AddContext(err, "cookie exists but it's not valid")
 	}
 	var value string
-	err = secureCookie.Decode(CookieName, cookie.Value, &value)
+	err = securecookie.DecodeMulti(CookieName, cookie.Value, &value, api.staticCookieCodecs...)
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
-	return value, nil
+	return value, true, nil
 }
 
 // tokenFromContext extracts the JWT token from the
@@ -271,3 +370,40 @@ func tokenExpiration(t *jwt.Token) (int64, error) {
 	}
 	return int64(claims["exp"].(float64)), nil
 }
+
+// identityFromClaims extracts the user's identity traits from the
+// `session.identity` claim, as issued by Kratos. The second return value
+// reports whether the claims contain an identity. See ValidateToken for the
+// structure of the claims.
+func identityFromClaims(claims jwt.MapClaims) (database.UserIdentity, bool) {
+	var id database.UserIdentity
+	session, _ := claims["session"].(map[string]interface{})
+	identity, ok := session["identity"].(map[string]interface{})
+	if !ok {
+		return id, false
+	}
+	traits, ok := identity["traits"].(map[string]interface{})
+	if !ok {
+		return id, false
+	}
+	id.Email, _ = traits["email"].(string)
+	if name, ok := traits["name"].(map[string]interface{}); ok {
+		id.FirstName, _ = name["first"].(string)
+		id.LastName, _ = name["last"].(string)
+	}
+	// The email is verified if it matches a verified verifiable address.
+	addresses, _ := identity["verifiable_addresses"].([]interface{})
+	for _, a := range addresses {
+		addr, ok := a.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, _ := addr["value"].(string)
+		verified, _ := addr["verified"].(bool)
+		if verified && id.Email != "" && strings.EqualFold(value, id.Email) {
+			id.EmailVerified = true
+			break
+		}
+	}
+	return id, true
+}
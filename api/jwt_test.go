@@ -0,0 +1,127 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestValidateClaims ensures validateClaims properly validates the time-based
+// claims, the issuer, the audience and the session of a token.
+func TestValidateClaims(t *testing.T) {
+	oldIss, oldAud, oldSkew := JWTIssuer, JWTAudience, JWTClockSkew
+	defer func() {
+		JWTIssuer, JWTAudience, JWTClockSkew = oldIss, oldAud, oldSkew
+	}()
+	JWTIssuer = "https://siasky.net/"
+	JWTAudience = "skynet-accounts"
+	JWTClockSkew = 30 * time.Second
+
+	now := time.Now().UTC()
+	// validClaims returns a fresh set of valid claims, so each test case can
+	// modify it as needed.
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"exp": float64(now.Add(time.Hour).Unix()),
+			"iat": float64(now.Unix()),
+			"nbf": float64(now.Unix()),
+			"iss": "https://siasky.net/",
+			"aud": []interface{}{"skynet-accounts", "other"},
+			"session": map[string]interface{}{
+				"active":     true,
+				"expires_at": now.Add(time.Hour).Format(time.RFC3339Nano),
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		err    error
+	}{
+		{name: "valid", modify: func(c jwt.MapClaims) {}},
+		{name: "single audience", modify: func(c jwt.MapClaims) { c["aud"] = "skynet-accounts" }},
+		{name: "exp within skew", modify: func(c jwt.MapClaims) { c["exp"] = float64(now.Add(-10 * time.Second).Unix()) }},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = float64(now.Add(-time.Minute).Unix()) }, err: ErrTokenExpired},
+		{name: "nbf within skew", modify: func(c jwt.MapClaims) { c["nbf"] = float64(now.Add(10 * time.Second).Unix()) }},
+		{name: "not valid yet", modify: func(c jwt.MapClaims) { c["nbf"] = float64(now.Add(time.Minute).Unix()) }, err: ErrTokenNotValidYet},
+		{name: "issued in future", modify: func(c jwt.MapClaims) { c["iat"] = float64(now.Add(time.Minute).Unix()) }, err: ErrTokenIssuedInFuture},
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.net/" }, err: ErrTokenInvalidIssuer},
+		{name: "missing issuer", modify: func(c jwt.MapClaims) { delete(c, "iss") }, err: ErrTokenInvalidIssuer},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = []interface{}{"other"} }, err: ErrTokenInvalidAudience},
+		{name: "inactive session", modify: func(c jwt.MapClaims) { c["session"].(map[string]interface{})["active"] = false }, err: ErrSessionInactive},
+		{name: "expired session", modify: func(c jwt.MapClaims) {
+			c["session"].(map[string]interface{})["expires_at"] = now.Add(-time.Minute).Format(time.RFC3339Nano)
+		}, err: ErrSessionExpired},
+	}
+	for _, tt := range tests {
+		claims := validClaims()
+		tt.modify(claims)
+		err := validateClaims(claims, now)
+		if tt.err == nil && err != nil {
+			t.Errorf("%s: expected no error, got %v", tt.name, err)
+		}
+		if tt.err != nil && !errors.Contains(err, tt.err) {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	// Tokens without an expiration time or a session are invalid.
+	claims := validClaims()
+	delete(claims, "exp")
+	if err := validateClaims(claims, now); err == nil {
+		t.Error("expected a token without `exp` to be invalid")
+	}
+	claims = validClaims()
+	delete(claims, "session")
+	if err := validateClaims(claims, now); err == nil {
+		t.Error("expected a token without `session` to be invalid")
+	}
+}
+
+// TestIdentityFromClaims ensures identityFromClaims extracts the identity
+// traits from Kratos' claims.
+func TestIdentityFromClaims(t *testing.T) {
+	claims := jwt.MapClaims{
+		"session": map[string]interface{}{
+			"identity": map[string]interface{}{
+				"traits": map[string]interface{}{
+					"email": "user@siasky.net",
+					"name": map[string]interface{}{
+						"first": "First",
+						"last":  "Last",
+					},
+				},
+				"verifiable_addresses": []interface{}{
+					map[string]interface{}{"value": "other@siasky.net", "verified": true},
+					map[string]interface{}{"value": "User@siasky.net", "verified": true},
+				},
+			},
+		},
+	}
+	id, ok := identityFromClaims(claims)
+	if !ok {
+		t.Fatal("expected the claims to contain an identity")
+	}
+	if id.Email != "user@siasky.net" || id.FirstName != "First" || id.LastName != "Last" || !id.EmailVerified {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+
+	// The email is not verified if its own address is not verified.
+	identity := claims["session"].(map[string]interface{})["identity"].(map[string]interface{})
+	identity["verifiable_addresses"] = []interface{}{
+		map[string]interface{}{"value": "user@siasky.net", "verified": false},
+		map[string]interface{}{"value": "other@siasky.net", "verified": true},
+	}
+	id, _ = identityFromClaims(claims)
+	if id.EmailVerified {
+		t.Fatal("expected the email not to be verified")
+	}
+
+	// Claims without an identity, like the ones we embed for API keys.
+	if _, ok = identityFromClaims(jwt.MapClaims{"sub": "695725d4-a345-4e68-919a-7395cb68484c"}); ok {
+		t.Fatal("expected the claims not to contain an identity")
+	}
+}
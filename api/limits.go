@@ -0,0 +1,225 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+	"github.com/NebulousLabs/skynet-accounts/skynet"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// opUpload is the limits check operation for uploads.
+	opUpload = "upload"
+	// opDownload is the limits check operation for downloads.
+	opDownload = "download"
+	// opRegistryRead is the limits check operation for registry reads.
+	opRegistryRead = "registry_read"
+	// opRegistryWrite is the limits check operation for registry writes.
+	opRegistryWrite = "registry_write"
+)
+
+var (
+	// ErrLimitReached is returned when the operation would take the user over
+	// one of the limits of their tier.
+	ErrLimitReached = errors.New("limit reached")
+
+	// limitsStatsTTL defines for how long we cache a user's stats for the
+	// purpose of checking their limits. The check is called by nginx on each
+	// request, so we can't afford to calculate the stats every time. This
+	// means that users can go over their limits by at most this much usage.
+	limitsStatsTTL = 10 * time.Second
+)
+
+type (
+	// limitsCheck is the outcome of checking an operation against the user's
+	// limits. Headers holds the limits and remaining allowances relevant to
+	// the operation, before performing it, keyed by the name of the response
+	// header in which we report them. Err is set when the operation is not
+	// allowed.
+	limitsCheck struct {
+		Headers map[string]int64
+		Err     error
+	}
+
+	// statsCache caches the users' tiers and stats, keyed by sub.
+	statsCache struct {
+		staticEntries *ttlMap
+	}
+
+	// statsCacheEntry is a single cached user with their stats.
+	statsCacheEntry struct {
+		user  database.User
+		stats database.UserStats
+	}
+)
+
+// newStatsCache returns a new, empty statsCache.
+func newStatsCache() *statsCache {
+	return &statsCache{
+		staticEntries: newTTLMap(),
+	}
+}
+
+// managedGet returns the cached user and stats for the given sub. The last
+// return value reports whether we have valid cached values.
+func (sc *statsCache) managedGet(sub string) (database.User, database.UserStats, bool) {
+	v, ok := sc.staticEntries.managedGet(sub)
+	if !ok {
+		return database.User{}, database.UserStats{}, false
+	}
+	e := v.(statsCacheEntry)
+	return e.user, e.stats, true
+}
+
+// managedSet caches the given user and stats for limitsStatsTTL.
+func (sc *statsCache) managedSet(sub string, u database.User, stats database.UserStats) {
+	sc.staticEntries.managedSet(sub, statsCacheEntry{user: u, stats: stats}, time.Now().Add(limitsStatsTTL))
+}
+
+// userLimitsHandler returns the limits of the current user's tier. The
+// gateway can use the upload and download speeds to throttle the user's
+// transfers.
+func (api *API) userLimitsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, true)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	limits, ok := database.LimitsForTier(u.Tier)
+	if !ok {
+		api.WriteError(w, errors.New("user has an invalid tier"), http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, limits)
+}
+
+// userLimitsCheckHandler checks whether the current user is allowed to perform
+// the operation given by the `op` param without going over the limits of
+// their tier. Supported operations are `upload`, `download`, `registry_read`
+// and `registry_write`. Uploads and downloads also take the size of the file
+// via the `size` param. It responds with 204 if the operation is allowed and
+// with 403 if it's not. The remaining allowances and, for uploads and
+// downloads, the maximum transfer speed are reported in the response headers in
+// both cases.
+//
+// This endpoint is meant to be called by nginx via `auth_request`, which only
+// understands 2xx, 401 and 403 responses and treats anything else as an
+// internal error.
+func (api *API) userLimitsCheckHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	if err = req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	op := req.Form.Get("op")
+	var size int64
+	if s := req.Form.Get("size"); s != "" {
+		size, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || size < 0 {
+			api.WriteError(w, errors.New("invalid parameter 'size'"), http.StatusBadRequest)
+			return
+		}
+	}
+	u, stats, ok := api.staticStatsCache.managedGet(sub)
+	if !ok {
+		pu, err := api.staticDB.UserBySub(req.Context(), sub, true)
+		if err != nil {
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+		ps, err := api.staticDB.UserStats(req.Context(), *pu)
+		if err != nil {
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+		u, stats = *pu, *ps
+		api.staticStatsCache.managedSet(sub, u, stats)
+	}
+	limits, ok := database.LimitsForTier(u.Tier)
+	if !ok {
+		api.WriteError(w, errors.New("user has an invalid tier"), http.StatusInternalServerError)
+		return
+	}
+	check, err := checkLimits(op, size, limits, stats)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	for header, value := range check.Headers {
+		w.Header().Set(header, strconv.FormatInt(value, 10))
+	}
+	if check.Err != nil {
+		api.WriteError(w, check.Err, http.StatusForbidden)
+		return
+	}
+	api.WriteSuccess(w)
+}
+
+// checkLimits checks whether the given operation, on a file of the given size
+// where applicable, would take the user over the given limits, based on their
+// current usage. It returns an error if the operation is unknown.
+func checkLimits(op string, size int64, limits database.TierLimits, stats database.UserStats) (limitsCheck, error) {
+	check := limitsCheck{Headers: make(map[string]int64)}
+	switch op {
+	case opUpload:
+		remainingStorage := remaining(limits.Storage, stats.StorageUsed)
+		remainingBandwidth := remaining(limits.UploadBandwidth, stats.BandwidthUploads)
+		check.Headers["Skynet-Max-Upload-Size"] = limits.MaxUploadSize
+		check.Headers["Skynet-Remaining-Storage"] = remainingStorage
+		check.Headers["Skynet-Remaining-Upload-Bandwidth"] = remainingBandwidth
+		check.Headers["Skynet-Upload-Speed"] = limits.UploadSpeed
+		switch {
+		case size > limits.MaxUploadSize:
+			check.Err = errors.AddContext(ErrLimitReached, "maximum upload size exceeded")
+		case skynet.StorageUsed(size) > remainingStorage:
+			check.Err = errors.AddContext(ErrLimitReached, "storage limit reached")
+		case skynet.BandwidthUploadCost(size) > remainingBandwidth:
+			check.Err = errors.AddContext(ErrLimitReached, "upload bandwidth limit reached")
+		}
+	case opDownload:
+		remainingBandwidth := remaining(limits.DownloadBandwidth, stats.BandwidthDownloads)
+		check.Headers["Skynet-Remaining-Download-Bandwidth"] = remainingBandwidth
+		check.Headers["Skynet-Download-Speed"] = limits.DownloadSpeed
+		if skynet.BandwidthDownloadCost(size) > remainingBandwidth {
+			check.Err = errors.AddContext(ErrLimitReached, "download bandwidth limit reached")
+		}
+	case opRegistryRead:
+		remainingReads := remaining(limits.RegistryReads, stats.NumRegReads)
+		check.Headers["Skynet-Remaining-Registry-Reads"] = remainingReads
+		if remainingReads < 1 {
+			check.Err = errors.AddContext(ErrLimitReached, "registry read limit reached")
+		}
+	case opRegistryWrite:
+		remainingWrites := remaining(limits.RegistryWrites, stats.NumRegWrites)
+		check.Headers["Skynet-Remaining-Registry-Writes"] = remainingWrites
+		if remainingWrites < 1 {
+			check.Err = errors.AddContext(ErrLimitReached, "registry write limit reached")
+		}
+	default:
+		return limitsCheck{}, errors.New("invalid parameter 'op'")
+	}
+	return check, nil
+}
+
+// remaining returns how much of the given limit is left after the given
+// usage. It never returns a negative value.
+func remaining(limit, used int64) int64 {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
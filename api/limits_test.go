@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+	"github.com/NebulousLabs/skynet-accounts/skynet"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestCheckLimits ensures checkLimits allows operations within the user's
+// limits, rejects the ones which would exceed them and reports the remaining
+// allowances.
+func TestCheckLimits(t *testing.T) {
+	limits := database.TierLimits{
+		Storage:           10 * skynet.GiB,
+		UploadBandwidth:   10 * skynet.GiB,
+		DownloadBandwidth: 10 * skynet.GiB,
+		MaxUploadSize:     skynet.GiB,
+		RegistryReads:     10,
+		RegistryWrites:    10,
+		UploadSpeed:       skynet.MiB,
+		DownloadSpeed:     2 * skynet.MiB,
+	}
+	stats := database.UserStats{
+		StorageUsed:        9 * skynet.GiB,
+		BandwidthUploads:   skynet.GiB,
+		BandwidthDownloads: 9 * skynet.GiB,
+		NumRegReads:        9,
+		NumRegWrites:       12,
+	}
+
+	tests := []struct {
+		op      string
+		size    int64
+		allowed bool
+		header  string
+		value   int64
+	}{
+		{op: opUpload, size: skynet.MiB, allowed: true, header: "Skynet-Remaining-Storage", value: skynet.GiB},
+		{op: opUpload, size: skynet.GiB + 1, allowed: false, header: "Skynet-Max-Upload-Size", value: skynet.GiB},
+		{op: opUpload, size: skynet.GiB, allowed: false, header: "Skynet-Remaining-Upload-Bandwidth", value: 9 * skynet.GiB},
+		{op: opDownload, size: skynet.MiB, allowed: true, header: "Skynet-Remaining-Download-Bandwidth", value: skynet.GiB},
+		{op: opDownload, size: 2 * skynet.GiB, allowed: false, header: "Skynet-Remaining-Download-Bandwidth", value: skynet.GiB},
+		{op: opUpload, size: skynet.MiB, allowed: true, header: "Skynet-Upload-Speed", value: skynet.MiB},
+		{op: opDownload, size: skynet.MiB, allowed: true, header: "Skynet-Download-Speed", value: 2 * skynet.MiB},
+		{op: opRegistryRead, allowed: true, header: "Skynet-Remaining-Registry-Reads", value: 1},
+		{op: opRegistryWrite, allowed: false, header: "Skynet-Remaining-Registry-Writes", value: 0},
+	}
+	for _, tt := range tests {
+		check, err := checkLimits(tt.op, tt.size, limits, stats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := check.Err == nil; allowed != tt.allowed {
+			t.Errorf("expected %s of size %d to be allowed: %t, got %t (%v)", tt.op, tt.size, tt.allowed, allowed, check.Err)
+		}
+		if check.Err != nil && !errors.Contains(check.Err, ErrLimitReached) {
+			t.Errorf("expected ErrLimitReached, got %v", check.Err)
+		}
+		if v, ok := check.Headers[tt.header]; !ok || v != tt.value {
+			t.Errorf("expected %s of size %d to report %s: %d, got %d", tt.op, tt.size, tt.header, tt.value, v)
+		}
+	}
+
+	if _, err := checkLimits("delete", 0, limits, stats); err == nil {
+		t.Error("expected an error for an unknown operation")
+	}
+}
+
+// TestUserLimitsCheckHandler ensures the limits check responds in a way nginx's
+// `auth_request` understands, i.e. with 204 or 403, and reports the remaining
+// allowances in both cases.
+func TestUserLimitsCheckHandler(t *testing.T) {
+	api := &API{
+		staticLogger:     logrus.New(),
+		staticStatsCache: newStatsCache(),
+	}
+	u := database.User{Sub: "a-sub", Tier: database.TierFree}
+	limits, _ := database.LimitsForTier(u.Tier)
+	stats := database.UserStats{NumRegWrites: limits.RegistryWrites}
+	api.staticStatsCache.managedSet(u.Sub, u, stats)
+	token := &jwt.Token{
+		Claims: jwt.MapClaims{
+			"sub": u.Sub,
+			"exp": float64(time.Now().Add(time.Hour).Unix()),
+		},
+		Valid: true,
+	}
+
+	tests := []struct {
+		op     string
+		status int
+		header string
+		value  int64
+	}{
+		{op: opRegistryRead, status: http.StatusNoContent, header: "Skynet-Remaining-Registry-Reads", value: limits.RegistryReads},
+		{op: opRegistryWrite, status: http.StatusForbidden, header: "Skynet-Remaining-Registry-Writes", value: 0},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/user/limits/check?op="+tt.op, nil)
+		req = req.WithContext(context.WithValue(req.Context(), ctxValue("token"), token))
+		w := httptest.NewRecorder()
+		api.userLimitsCheckHandler(w, req, nil)
+		if w.Code != tt.status {
+			t.Errorf("expected %s to respond with %d, got %d: %s", tt.op, tt.status, w.Code, w.Body.String())
+		}
+		if v := w.Header().Get(tt.header); v != strconv.FormatInt(tt.value, 10) {
+			t.Errorf("expected %s to report %s: %d, got %s", tt.op, tt.header, tt.value, v)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	// UsageNotificationThresholds are the percentages of their tier's limits
+	// at which we notify the users about their usage. The point of this var
+	// is to be overridable via .env.
+	UsageNotificationThresholds = []int{80, 100}
+
+	// usageCheckInterval is the minimum amount of time between two checks of
+	// the same user's usage. We check it each time we track an upload or a
+	// download, so we need to throttle it.
+	usageCheckInterval = time.Minute
+	// usageCheckTimeout is the maximum amount of time a single usage check
+	// can take.
+	usageCheckTimeout = time.Minute
+)
+
+// userNotificationsHandler returns the current user's notifications.
+func (api *API) userNotificationsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, true)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	ns, err := api.staticDB.NotificationsByUser(req.Context(), *u)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, ns)
+}
+
+// checkUsage checks the given user's usage in the background and notifies
+// them when it reaches one of the UsageNotificationThresholds. Each user is
+// checked at most once per usageCheckInterval.
+func (api *API) checkUsage(sub string, u database.User) {
+	if len(UsageNotificationThresholds) == 0 || !api.staticUsageChecks.managedAllow(u.ID.Hex()) {
+		return
+	}
+	go api.threadedCheckUsage(sub, u)
+}
+
+// threadedCheckUsage calculates the given user's stats and creates the
+// notifications for the thresholds they have reached. It also refreshes the
+// cached stats we use for checking the user's limits.
+func (api *API) threadedCheckUsage(sub string, u database.User) {
+	ctx, cancel := context.WithTimeout(context.Background(), usageCheckTimeout)
+	defer cancel()
+	stats, err := api.staticDB.UserStats(ctx, u)
+	if err != nil {
+		api.staticLogger.Debugf("Failed to check the usage of user %s: %v", u.ID.Hex(), err)
+		return
+	}
+	api.staticStatsCache.managedSet(sub, u, *stats)
+	ns, err := api.staticDB.NotificationsEvaluate(ctx, u, *stats, UsageNotificationThresholds)
+	if err != nil {
+		api.staticLogger.Debugf("Failed to notify user %s about their usage: %v", u.ID.Hex(), err)
+		return
+	}
+	for _, n := range ns {
+		api.staticLogger.Tracef("User %s reached %d%% of their %s limit.", u.ID.Hex(), n.Threshold, n.Resource)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// promoCodeReport describes a promo code together with its redemptions.
+	promoCodeReport struct {
+		*database.PromoCode
+		RedemptionsList []database.PromoRedemption `json:"redemptionsList"`
+	}
+)
+
+// userRedeemHandler redeems the promo code given by the `code` param for the
+// current user.
+func (api *API) userRedeemHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	if err = req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	code := req.Form.Get("code")
+	if code == "" {
+		api.WriteError(w, errors.New("missing parameter 'code'"), http.StatusBadRequest)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, true)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	pr, err := api.staticDB.PromoCodeRedeem(req.Context(), u, code)
+	switch {
+	case err == nil:
+	case errors.Contains(err, database.ErrPromoCodeNotFound):
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	case errors.Contains(err, database.ErrPromoCodeExpired),
+		errors.Contains(err, database.ErrPromoCodeExhausted),
+		errors.Contains(err, database.ErrPromoCodeTierTooLow):
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	case errors.Contains(err, database.ErrPromoCodeRedeemed),
+		errors.Contains(err, database.ErrSubscriptionChanged):
+		api.WriteError(w, err, http.StatusConflict)
+		return
+	default:
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, pr)
+}
+
+// adminPromoCodesPOSTHandler creates a new promo code which grants the tier
+// given by the `tier` param for the number of months given by the `months`
+// param. The optional `code` param sets the code, otherwise we generate one.
+// The optional `maxRedemptions` and `expiresAt` params limit how many times
+// and until when the code can be redeemed.
+func (api *API) adminPromoCodesPOSTHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	var pc database.PromoCode
+	var err error
+	pc.Code = req.Form.Get("code")
+	pc.Tier, err = strconv.Atoi(req.Form.Get("tier"))
+	if err != nil {
+		api.WriteError(w, errors.New("invalid parameter 'tier'"), http.StatusBadRequest)
+		return
+	}
+	pc.Months, err = strconv.Atoi(req.Form.Get("months"))
+	if err != nil {
+		api.WriteError(w, errors.New("invalid parameter 'months'"), http.StatusBadRequest)
+		return
+	}
+	if s := req.Form.Get("maxRedemptions"); s != "" {
+		pc.MaxRedemptions, err = strconv.Atoi(s)
+		if err != nil {
+			api.WriteError(w, errors.New("invalid parameter 'maxRedemptions'"), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := req.Form.Get("expiresAt"); s != "" {
+		pc.ExpiresAt, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			api.WriteError(w, errors.AddContext(err, "invalid parameter 'expiresAt'"), http.StatusBadRequest)
+			return
+		}
+	}
+	created, err := api.staticDB.PromoCodeCreate(req.Context(), pc)
+	if errors.Contains(err, database.ErrPromoCodeExists) {
+		api.WriteError(w, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	api.WriteJSON(w, created)
+}
+
+// adminPromoCodesGETHandler returns all promo codes.
+func (api *API) adminPromoCodesGETHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	codes, err := api.staticDB.PromoCodes(req.Context())
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, codes)
+}
+
+// adminPromoCodeHandler returns the promo code given by the `code` param
+// together with all of its redemptions.
+func (api *API) adminPromoCodeHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	pc, err := api.staticDB.PromoCodeByCode(req.Context(), ps.ByName("code"))
+	if errors.Contains(err, database.ErrPromoCodeNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	rs, err := api.staticDB.PromoRedemptionsByCode(req.Context(), *pc)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, promoCodeReport{PromoCode: pc, RedemptionsList: rs})
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// ErrTokenRevoked is returned when the token has been revoked, e.g.
+	// because the user logged out.
+	ErrTokenRevoked = errors.New("token has been revoked")
+
+	// revocationCacheTTL defines for how long we trust a cached "not revoked"
+	// answer. Revocations made by other instances of this service become
+	// effective here after at most that much time. Revocations made by this
+	// instance are effective immediately.
+	revocationCacheTTL = 30 * time.Second
+)
+
+// revocationCache caches the revocation status of tokens, so we don't need
+// to hit the DB on each request. Revoked tokens are cached until they expire
+// because revocation is permanent. Tokens which are not revoked are cached for
+// revocationCacheTTL.
+type revocationCache struct {
+	staticEntries *ttlMap
+}
+
+// newRevocationCache returns a new, empty revocationCache.
+func newRevocationCache() *revocationCache {
+	return &revocationCache{
+		staticEntries: newTTLMap(),
+	}
+}
+
+// managedGet returns the cached revocation status of the given id. The second
+// return value reports whether we have a valid cached status.
+func (rc *revocationCache) managedGet(id string) (revoked bool, ok bool) {
+	v, ok := rc.staticEntries.managedGet(id)
+	if !ok {
+		return false, false
+	}
+	return v.(bool), true
+}
+
+// managedSet caches the revocation status of the given id until validUntil.
+func (rc *revocationCache) managedSet(id string, revoked bool, validUntil time.Time) {
+	rc.staticEntries.managedSet(id, revoked, validUntil)
+}
+
+// tokenRevoked checks whether the given token has been revoked. Tokens without
+// a `jti` claim cannot be revoked.
+func (api *API) tokenRevoked(ctx context.Context, token *jwt.Token) (bool, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false, errors.New("the token does not contain the claims we expect")
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return false, nil
+	}
+	if revoked, ok := api.staticRevokedTokens.managedGet(jti); ok {
+		return revoked, nil
+	}
+	revoked, err := api.staticDB.TokenIsRevoked(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+	validUntil := time.Now().Add(revocationCacheTTL)
+	if revoked {
+		exp, err := tokenExpiration(token)
+		if err != nil {
+			return false, err
+		}
+		validUntil = time.Unix(exp, 0)
+	}
+	api.staticRevokedTokens.managedSet(jti, revoked, validUntil)
+	return revoked, nil
+}
+
+// revokeToken revokes the given token until its expiration. Tokens without a
+// `jti` can't be revoked, so there is nothing to do for them.
+func (api *API) revokeToken(ctx context.Context, token *jwt.Token) error {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return errors.New("the token does not contain the claims we expect")
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil
+	}
+	exp, err := tokenExpiration(token)
+	if err != nil {
+		return err
+	}
+	return api.revokeJTI(ctx, jti, time.Unix(exp, 0).UTC())
+}
+
+// revokeJTI revokes the token with the given `jti` until the given expiration
+// time.
+func (api *API) revokeJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	err := api.staticDB.TokenRevoke(ctx, jti, expiresAt)
+	if err != nil {
+		return err
+	}
+	api.staticRevokedTokens.managedSet(jti, true, expiresAt)
+	return nil
+}
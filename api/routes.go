@@ -4,7 +4,11 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"github.com/dgrijalva/jwt-go"
 	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
 )
 
 // buildHTTPRoutes registers all HTTP routes and their handlers.
@@ -12,35 +16,180 @@ func (api *API) buildHTTPRoutes() {
 	api.staticRouter.POST("/login", api.loginHandler)
 	api.staticRouter.POST("/logout", api.validate(api.logoutHandler))
 
-	api.staticRouter.POST("/track/upload/:skylink", api.validate(api.trackUploadHandler))
-	api.staticRouter.POST("/track/download/:skylink", api.validate(api.trackDownloadHandler))
-	api.staticRouter.POST("/track/registry/read", api.validate(api.trackRegistryReadHandler))
-	api.staticRouter.POST("/track/registry/write", api.validate(api.trackRegistryWriteHandler))
+	api.staticRouter.GET("/limits", api.limitsHandler)
+
+	api.staticRouter.POST("/stripe/webhook", api.stripeWebhookHandler)
+
+	api.staticRouter.POST("/track/upload/:skylink", api.validate(api.trackUploadHandler, database.ScopeTrackWrite))
+	api.staticRouter.POST("/track/download/:skylink", api.validate(api.trackDownloadHandler, database.ScopeTrackWrite))
+	api.staticRouter.POST("/track/registry/read", api.validate(api.trackRegistryReadHandler, database.ScopeTrackWrite))
+	api.staticRouter.POST("/track/registry/write", api.validate(api.trackRegistryWriteHandler, database.ScopeTrackWrite))
+
+	api.staticRouter.GET("/user", api.validate(api.userHandler, database.ScopeUserRead))
+	api.staticRouter.PUT("/user", api.validate(api.userPUTHandler))
+	api.staticRouter.DELETE("/user", api.validate(api.userDELETEHandler))
+	api.staticRouter.GET("/user/export", api.validate(api.userExportHandler))
+	api.staticRouter.GET("/user/stats", api.validate(api.userStatsHandler, database.ScopeStatsRead))
+	api.staticRouter.GET("/user/stats/daily", api.validate(api.userStatsDailyHandler, database.ScopeStatsRead))
+	api.staticRouter.GET("/user/stats/hourly", api.validate(api.userStatsHourlyHandler, database.ScopeStatsRead))
+	api.staticRouter.GET("/user/uploads", api.validate(api.userUploadsHandler, database.ScopeStatsRead))
+	api.staticRouter.GET("/user/downloads", api.validate(api.userDownloadsHandler, database.ScopeStatsRead))
+	api.staticRouter.GET("/user/limits", api.validate(api.userLimitsHandler, database.ScopeUserRead))
+	api.staticRouter.GET("/user/limits/check", api.validate(api.userLimitsCheckHandler, database.ScopeStatsRead))
 
-	api.staticRouter.GET("/user", api.validate(api.userHandler))
-	api.staticRouter.GET("/user/stats", api.validate(api.userStatsHandler))
-	api.staticRouter.GET("/user/uploads", api.validate(api.userUploadsHandler))
-	api.staticRouter.GET("/user/downloads", api.validate(api.userDownloadsHandler))
+	api.staticRouter.POST("/user/redeem", api.validate(api.userRedeemHandler))
+	api.staticRouter.GET("/user/subscription/changes", api.validate(api.userSubscriptionChangesHandler, database.ScopeUserRead))
+	api.staticRouter.GET("/user/notifications", api.validate(api.userNotificationsHandler, database.ScopeUserRead))
+
+	api.staticRouter.POST("/user/apikeys", api.validate(api.userAPIKeysPOSTHandler))
+	api.staticRouter.GET("/user/apikeys", api.validate(api.userAPIKeysGETHandler))
+	api.staticRouter.DELETE("/user/apikeys/:id", api.validate(api.userAPIKeysDELETEHandler))
+
+	api.staticRouter.GET("/user/sessions", api.validate(api.userSessionsGETHandler))
+	api.staticRouter.DELETE("/user/sessions/:id", api.validate(api.userSessionsDELETEHandler))
+
+	api.staticRouter.POST("/admin/tokens/revoke", api.validate(api.requireRole(database.RoleAdmin, api.adminTokenRevokeHandler)))
+	api.staticRouter.POST("/admin/promocodes", api.validate(api.requireRole(database.RoleAdmin, api.adminPromoCodesPOSTHandler)))
+	api.staticRouter.GET("/admin/promocodes", api.validate(api.requireRole(database.RoleAdmin, api.adminPromoCodesGETHandler)))
+	api.staticRouter.GET("/admin/promocodes/:code", api.validate(api.requireRole(database.RoleAdmin, api.adminPromoCodeHandler)))
+	api.staticRouter.GET("/admin/users", api.validate(api.requireRole(database.RoleAdmin, api.adminUsersHandler)))
+	api.staticRouter.GET("/admin/users/:id", api.validate(api.requireRole(database.RoleAdmin, api.adminUserHandler)))
+	api.staticRouter.PUT("/admin/users/:id", api.validate(api.requireRole(database.RoleAdmin, api.adminUserPUTHandler)))
+	api.staticRouter.DELETE("/admin/users/:id", api.validate(api.requireRole(database.RoleAdmin, api.adminUserDELETEHandler)))
+	api.staticRouter.GET("/admin/users/:id/stats", api.validate(api.requireRole(database.RoleAdmin, api.adminUserStatsHandler)))
+	api.staticRouter.GET("/admin/users/:id/subscription/changes", api.validate(api.requireRole(database.RoleAdmin, api.adminUserSubscriptionChangesHandler)))
+	api.staticRouter.GET("/admin/users/:id/uploads", api.validate(api.requireRole(database.RoleAdmin, api.adminUserUploadsHandler)))
+	api.staticRouter.GET("/admin/users/:id/downloads", api.validate(api.requireRole(database.RoleAdmin, api.adminUserDownloadsHandler)))
 }
 
 // validate ensures that the user making the request has logged in.
-func (api *API) validate(h httprouter.Handle) httprouter.Handle {
+//
+// Requests can also be authenticated with an API key, passed via the
+// APIKeyHeader header, but only on routes which specify the scopes an API key
+// needs in order to access them. Routes without scopes are only available to
+// users with a valid JWT.
+func (api *API) validate(h httprouter.Handle, scopes ...string) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.staticLogger.Tracef("Processing request: %+v", req)
-		tokenStr, err := tokenFromRequest(req)
+		if key := req.Header.Get(APIKeyHeader); key != "" {
+			api.validateAPIKey(h, key, scopes)(w, req, ps)
+			return
+		}
+		tokenStr, fromCookie, err := api.tokenFromRequest(req)
 		if err != nil {
 			api.staticLogger.Traceln("Error fetching token from request:", err)
 			api.WriteError(w, err, http.StatusUnauthorized)
 			return
 		}
-		token, err := ValidateToken(api.staticLogger, tokenStr)
+		if fromCookie && CSRFProtection {
+			if err = checkCSRF(req); err != nil {
+				api.staticLogger.Traceln("Error checking CSRF:", err)
+				api.WriteError(w, err, http.StatusForbidden)
+				return
+			}
+		}
+		token, err := ValidateToken(api.staticAuth, tokenStr)
 		if err != nil {
 			api.staticLogger.Traceln("Error validating token:", err)
 			api.WriteError(w, err, http.StatusUnauthorized)
 			return
 		}
+		revoked, err := api.tokenRevoked(req.Context(), token)
+		if err != nil {
+			api.staticLogger.Traceln("Error checking token revocation:", err)
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			api.WriteError(w, ErrTokenRevoked, http.StatusUnauthorized)
+			return
+		}
+		revoked, err = api.sessionRevoked(req.Context(), token)
+		if err != nil {
+			api.staticLogger.Traceln("Error checking session revocation:", err)
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			api.WriteError(w, ErrSessionRevoked, http.StatusUnauthorized)
+			return
+		}
+		api.recordSession(req, token, false)
 		// Embed the verified token in the context of the request.
 		ctx := context.WithValue(req.Context(), ctxValue("token"), token)
 		h(w, req.WithContext(ctx), ps)
 	}
 }
+
+// requireRole ensures that the user making the request has at least the given
+// role. It needs to be wrapped by validate because it relies on the token
+// embedded in the request's context. Roles are ordered by privilege, so users
+// with a higher role also have the privileges of the lower ones.
+func (api *API) requireRole(role int, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		sub, _, _, err := tokenFromContext(req)
+		if err != nil {
+			api.WriteError(w, err, http.StatusUnauthorized)
+			return
+		}
+		u, err := api.staticDB.UserBySub(req.Context(), sub, false)
+		if errors.Contains(err, database.ErrUserNotFound) {
+			api.WriteError(w, ErrInsufficientPrivileges, http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if u.Role < role {
+			api.staticLogger.Tracef("User %s with role %d tried to access %s", u.ID.Hex(), u.Role, req.URL.Path)
+			api.WriteError(w, ErrInsufficientPrivileges, http.StatusForbidden)
+			return
+		}
+		h(w, req, ps)
+	}
+}
+
+// validateAPIKey authenticates the request with the given API key and ensures
+// the key has all the given scopes.
+func (api *API) validateAPIKey(h httprouter.Handle, key string, scopes []string) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		ak, err := api.staticDB.APIKeyByKey(req.Context(), key)
+		if errors.Contains(err, database.ErrAPIKeyNotFound) {
+			api.WriteError(w, err, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if len(scopes) == 0 {
+			api.WriteError(w, errors.New("this endpoint does not accept API keys"), http.StatusForbidden)
+			return
+		}
+		for _, s := range scopes {
+			if !ak.HasScope(s) {
+				api.WriteError(w, errors.New("the API key lacks the required scope "+s), http.StatusForbidden)
+				return
+			}
+		}
+		u, err := api.staticDB.UserByID(req.Context(), ak.UserID)
+		if errors.Contains(err, database.ErrUserNotFound) {
+			api.WriteError(w, err, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+		// API keys act on behalf of their owner, so we embed a token which
+		// only carries the owner's sub. This allows the handlers to treat
+		// requests authenticated by JWT and by API key in the same way.
+		token := &jwt.Token{
+			Claims: jwt.MapClaims{"sub": u.Sub},
+			Valid:  true,
+		}
+		ctx := context.WithValue(req.Context(), ctxValue("token"), token)
+		h(w, req.WithContext(ctx), ps)
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// ErrSessionRevoked is returned when the user has signed out of the
+	// token's session remotely.
+	ErrSessionRevoked = errors.New("session has been revoked")
+
+	// sessionTouchInterval is the minimum amount of time between two updates
+	// of a session's last seen time. This way we don't need to write to the
+	// DB on each request.
+	sessionTouchInterval = time.Minute
+)
+
+// userSessionsGETHandler returns all active sessions of the current user.
+func (api *API) userSessionsGETHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sub, claims, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, false)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	sessions, err := api.staticDB.SessionsByUser(req.Context(), *u)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	currentID, _, _ := sessionFromClaims(claims)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionID == currentID
+	}
+	api.WriteJSON(w, sessions)
+}
+
+// userSessionsDELETEHandler signs the current user out of the session with the
+// given id. All tokens issued for that session are rejected afterwards.
+func (api *API) userSessionsDELETEHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, false)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	id := ps.ByName("id")
+	err = api.staticDB.SessionRevoke(req.Context(), *u, id)
+	if errors.Contains(err, database.ErrSessionNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.staticRevokedSessions.managedSet(id, true, time.Now().Add(revocationCacheTTL))
+	api.WriteSuccess(w)
+}
+
+// sessionRevoked checks whether the session of the given token has been
+// revoked. Tokens without a session id cannot be revoked this way.
+func (api *API) sessionRevoked(ctx context.Context, token *jwt.Token) (bool, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false, errors.New("the token does not contain the claims we expect")
+	}
+	id, _, expiresAt := sessionFromClaims(claims)
+	if id == "" {
+		return false, nil
+	}
+	if revoked, ok := api.staticRevokedSessions.managedGet(id); ok {
+		return revoked, nil
+	}
+	revoked, err := api.staticDB.SessionIsRevoked(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	validUntil := time.Now().Add(revocationCacheTTL)
+	if revoked && expiresAt.After(validUntil) {
+		validUntil = expiresAt
+	}
+	api.staticRevokedSessions.managedSet(id, revoked, validUntil)
+	return revoked, nil
+}
+
+// recordSession records the session of the given token, unless we've recorded
+// it recently. When force is true we always record it. Failing to record a
+// session is not an error, so we only log it.
+func (api *API) recordSession(req *http.Request, token *jwt.Token, force bool) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return
+	}
+	id, authenticatedAt, expiresAt := sessionFromClaims(claims)
+	if id == "" {
+		return
+	}
+	if force {
+		api.staticSessionTouches.managedMark(id)
+	} else if !api.staticSessionTouches.managedAllow(id) {
+		return
+	}
+	sub, _ := claims["sub"].(string)
+	u, err := api.staticDB.UserBySub(req.Context(), sub, false)
+	if err != nil {
+		// The user might not have been created yet. We'll record the session
+		// on one of the next requests.
+		api.staticLogger.Traceln("Error fetching the session's user:", err)
+		return
+	}
+	jti, _ := claims["jti"].(string)
+	now := time.Now().UTC()
+	if authenticatedAt.IsZero() {
+		authenticatedAt = now
+	}
+	s := database.Session{
+		SessionID: id,
+		UserID:    u.ID,
+		JTI:       jti,
+		UserAgent: req.UserAgent(),
+		IP:        clientIP(req),
+		FirstSeen: authenticatedAt,
+		LastSeen:  now,
+		ExpiresAt: expiresAt,
+	}
+	err = api.staticDB.SessionTouch(req.Context(), s)
+	if err != nil {
+		api.staticLogger.Debugln("Error recording session:", err)
+	}
+}
+
+// sessionFromClaims extracts the id, authentication time and expiration time
+// of the Kratos session from the `session` claim. The id is empty if the
+// claims don't contain a session. See ValidateToken for the structure of the
+// claims.
+func sessionFromClaims(claims jwt.MapClaims) (id string, authenticatedAt time.Time, expiresAt time.Time) {
+	session, ok := claims["session"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	id, _ = session["id"].(string)
+	if s, ok := session["authenticated_at"].(string); ok {
+		authenticatedAt, _ = time.Parse(time.RFC3339Nano, s)
+	}
+	if s, ok := session["expires_at"].(string); ok {
+		expiresAt, _ = time.Parse(time.RFC3339Nano, s)
+	}
+	return
+}
+
+// clientIP returns the IP address of the client making the request. We run
+// behind a reverse proxy, so we prefer the first address in the
+// X-Forwarded-For header. The result is for display purposes only and must not
+// be used for access control because clients can forge the header.
+func clientIP(req *http.Request) string {
+	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// TestSessionFromClaims ensures sessionFromClaims extracts the session from
+// Kratos' claims.
+func TestSessionFromClaims(t *testing.T) {
+	claims := jwt.MapClaims{
+		"session": map[string]interface{}{
+			"id":               "9911ad26-e47f-4ec4-86a1-fbbc7fd5073e",
+			"authenticated_at": "2020-12-09T16:09:35.004003Z",
+			"expires_at":       "2020-12-10T16:09:35.004003Z",
+		},
+	}
+	id, authenticatedAt, expiresAt := sessionFromClaims(claims)
+	if id != "9911ad26-e47f-4ec4-86a1-fbbc7fd5073e" {
+		t.Fatalf("unexpected session id '%s'", id)
+	}
+	if authenticatedAt.Day() != 9 || expiresAt.Day() != 10 {
+		t.Fatalf("unexpected times %v, %v", authenticatedAt, expiresAt)
+	}
+	if id, _, _ = sessionFromClaims(jwt.MapClaims{}); id != "" {
+		t.Fatalf("expected no session id, got '%s'", id)
+	}
+}
+
+// TestClientIP ensures clientIP prefers the X-Forwarded-For header.
+func TestClientIP(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "10.0.0.1:1234"
+	if ip := clientIP(req); ip != "10.0.0.1" {
+		t.Fatalf("expected 10.0.0.1, got %s", ip)
+	}
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.1")
+	if ip := clientIP(req); ip != "1.2.3.4" {
+		t.Fatalf("expected 1.2.3.4, got %s", ip)
+	}
+}
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// stripeSignatureHeader is the header in which Stripe sends the signature
+	// of a webhook event.
+	stripeSignatureHeader = "Stripe-Signature"
+	// stripeSignatureTolerance is the maximum age of a webhook event we
+	// accept. It protects us against replay attacks.
+	stripeSignatureTolerance = 5 * time.Minute
+	// stripeMaxBodySize is the maximum size of a webhook event we accept.
+	stripeMaxBodySize = 1 << 16
+
+	// stripeEventSubscriptionCreated is sent when a customer subscribes.
+	stripeEventSubscriptionCreated = "customer.subscription.created"
+	// stripeEventSubscriptionUpdated is sent when a subscription changes, e.g.
+	// when it's renewed or its plan changes.
+	stripeEventSubscriptionUpdated = "customer.subscription.updated"
+	// stripeEventSubscriptionDeleted is sent when a subscription ends.
+	stripeEventSubscriptionDeleted = "customer.subscription.deleted"
+
+	// stripeMetadataSub is the key of the subscription's metadata which holds
+	// the sub of the user who subscribed.
+	stripeMetadataSub = "sub"
+)
+
+var (
+	// StripeWebhookSecret is the secret with which Stripe signs the webhook
+	// events it sends us. The webhook is disabled when it's empty. The point
+	// of this var is to be overridable via .env.
+	StripeWebhookSecret string
+	// StripePriceTiers maps the ids of our Stripe prices to the tiers they
+	// pay for. The point of this var is to be overridable via .env.
+	StripePriceTiers = make(map[string]int)
+
+	// ErrInvalidSignature is returned when a webhook event doesn't carry a
+	// valid signature.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrUnknownPrice is returned when a subscription doesn't pay for any of
+	// the prices in StripePriceTiers.
+	ErrUnknownPrice = errors.New("subscription has no known price")
+)
+
+type (
+	// stripeEvent is a webhook event sent by Stripe. We only parse the fields
+	// we need.
+	// See https://stripe.com/docs/api/events/object
+	stripeEvent struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Created int64  `json:"created"`
+		Data    struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+
+	// stripeSubscription is a Stripe subscription. We only parse the fields
+	// we need.
+	// See https://stripe.com/docs/api/subscriptions/object
+	stripeSubscription struct {
+		ID               string            `json:"id"`
+		Customer         string            `json:"customer"`
+		Status           string            `json:"status"`
+		CurrentPeriodEnd int64             `json:"current_period_end"`
+		Metadata         map[string]string `json:"metadata"`
+		Items            struct {
+			Data []struct {
+				Price struct {
+					ID string `json:"id"`
+				} `json:"price"`
+			} `json:"data"`
+		} `json:"items"`
+	}
+)
+
+// stripeWebhookHandler receives the subscription events sent by Stripe and
+// changes the tier and subscription expiration of the respective users. Each
+// event is processed only once, even if Stripe sends it multiple times.
+//
+// See https://stripe.com/docs/webhooks
+func (api *API) stripeWebhookHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if StripeWebhookSecret == "" {
+		api.WriteError(w, errors.New("the webhook is not configured"), http.StatusNotFound)
+		return
+	}
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, stripeMaxBodySize))
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to read the request body"), http.StatusBadRequest)
+		return
+	}
+	err = verifyStripeSignature(payload, req.Header.Get(stripeSignatureHeader), StripeWebhookSecret, time.Now())
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	var ev stripeEvent
+	err = json.Unmarshal(payload, &ev)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to parse the event"), http.StatusBadRequest)
+		return
+	}
+	switch ev.Type {
+	case stripeEventSubscriptionCreated, stripeEventSubscriptionUpdated, stripeEventSubscriptionDeleted:
+	default:
+		// We don't care about any other events.
+		api.WriteSuccess(w)
+		return
+	}
+	err = api.staticDB.WebhookEventCreate(req.Context(), ev.ID, ev.Type)
+	if errors.Contains(err, database.ErrWebhookEventExists) {
+		api.staticLogger.Debugf("Ignoring Stripe event %s which we have already received.", ev.ID)
+		api.WriteSuccess(w)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	err = api.processStripeEvent(req.Context(), ev)
+	if err != nil {
+		// Forget the event, so we process it again when Stripe retries it.
+		if errDel := api.staticDB.WebhookEventDelete(req.Context(), ev.ID); errDel != nil {
+			err = errors.Compose(err, errDel)
+		}
+		api.staticLogger.Warnf("Failed to process Stripe event %s: %v", ev.ID, err)
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteSuccess(w)
+}
+
+// processStripeEvent applies the subscription change described by the given
+// event to the respective user. Events about customers or prices we don't know
+// are ignored, as are events which are out of date, see stripeEventApplies.
+func (api *API) processStripeEvent(ctx context.Context, ev stripeEvent) error {
+	var s stripeSubscription
+	err := json.Unmarshal(ev.Data.Object, &s)
+	if err != nil {
+		return errors.AddContext(err, "failed to parse the subscription")
+	}
+	tier, until, ok, err := stripeSubscriptionTier(ev.Type, s)
+	if errors.Contains(err, ErrUnknownPrice) {
+		// Stripe would keep retrying the event for days without any chance
+		// of success, so we acknowledge it and leave it to the operator.
+		api.staticLogger.Warnf("Ignoring Stripe event %s: %v", ev.ID, err)
+		return nil
+	}
+	if err != nil || !ok {
+		return err
+	}
+	u, err := api.stripeUser(ctx, s)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.staticLogger.Warnf("Ignoring Stripe event %s for unknown customer %s.", ev.ID, s.Customer)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	created := time.Unix(ev.Created, 0).UTC()
+	if !stripeEventApplies(*u, s.ID, created, tier) {
+		api.staticLogger.Debugf("Ignoring out of date Stripe event %s for subscription %s.", ev.ID, s.ID)
+		return nil
+	}
+	err = api.staticDB.UserSetStripeSubscription(ctx, u, s.ID, created)
+	if errors.Contains(err, database.ErrStaleStripeEvent) {
+		api.staticLogger.Debugf("Ignoring out of date Stripe event %s for subscription %s.", ev.ID, s.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Stripe decides when changes take effect, so we apply them immediately.
+	// We retry a few times in case the user's subscription changes
+	// concurrently.
+	for i := 0; ; i++ {
+		_, err = api.staticDB.UserChangeSubscription(ctx, u, tier, until, database.SubscriptionSourcePayment, true)
+		if !errors.Contains(err, database.ErrSubscriptionChanged) || i == 2 {
+			return err
+		}
+		u, err = api.staticDB.UserByID(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stripeUser returns the user to whom the given subscription belongs. We
+// identify them by the sub in the subscription's metadata and fall back to
+// their Stripe customer id. We store the customer id when we first see it.
+func (api *API) stripeUser(ctx context.Context, s stripeSubscription) (*database.User, error) {
+	sub := s.Metadata[stripeMetadataSub]
+	if sub == "" {
+		return api.staticDB.UserByStripeCustomerID(ctx, s.Customer)
+	}
+	u, err := api.staticDB.UserBySub(ctx, sub, false)
+	if err != nil {
+		return nil, err
+	}
+	if s.Customer != "" && s.Customer != u.StripeCustomerID {
+		err = api.staticDB.UserSetStripeCustomerID(ctx, u, s.Customer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// stripeSubscriptionTier returns the tier and subscription expiration the
+// user should have, based on the given subscription event. It returns false
+// if the event shouldn't change the user's subscription, e.g. because the
+// payment is still pending.
+func stripeSubscriptionTier(eventType string, s stripeSubscription) (int, time.Time, bool, error) {
+	if eventType == stripeEventSubscriptionDeleted {
+		return database.TierFree, time.Time{}, true, nil
+	}
+	switch s.Status {
+	case "active", "trialing":
+	case "canceled", "unpaid", "incomplete_expired":
+		return database.TierFree, time.Time{}, true, nil
+	default:
+		// The subscription is "incomplete" or "past_due". Stripe is still
+		// trying to collect the payment, so we leave the user's subscription
+		// as it is. If the payment fails, the subscription expires.
+		return 0, time.Time{}, false, nil
+	}
+	var prices []string
+	for _, item := range s.Items.Data {
+		if tier, exists := StripePriceTiers[item.Price.ID]; exists {
+			return tier, time.Unix(s.CurrentPeriodEnd, 0).UTC(), true, nil
+		}
+		prices = append(prices, item.Price.ID)
+	}
+	return 0, time.Time{}, false, errors.AddContext(ErrUnknownPrice, "subscription "+s.ID+" with prices "+strings.Join(prices, ", "))
+}
+
+// stripeEventApplies reports whether an event about the given subscription,
+// created at the given time, should change the user's tier to the given one.
+// Stripe doesn't guarantee the order in which it delivers events, so we ignore
+// events older than the last one we applied. We also ignore events which would
+// downgrade the user because a subscription other than their current one
+// ended, e.g. after they replaced their subscription with a new one.
+func stripeEventApplies(u database.User, subscriptionID string, created time.Time, tier int) bool {
+	if created.Before(u.StripeEventCreatedAt) {
+		return false
+	}
+	if u.StripeSubscriptionID != "" && u.StripeSubscriptionID != subscriptionID && tier == database.TierFree {
+		return false
+	}
+	return true
+}
+
+// verifyStripeSignature verifies the signature of a Stripe webhook event. The
+// signature header has the format `t=<timestamp>,v1=<signature>[,v1=...]`,
+// where the signature is the hex-encoded HMAC-SHA256 of
+// `<timestamp>.<payload>`, keyed with the webhook's secret. We reject events
+// which are older than stripeSignatureTolerance.
+//
+// See https://stripe.com/docs/webhooks/signatures
+func verifyStripeSignature(payload []byte, header, secret string, now time.Time) error {
+	var timestamp string
+	var sigs [][]byte
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			if sig, err := hex.DecodeString(kv[1]); err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return errors.AddContext(ErrInvalidSignature, "malformed signature header")
+	}
+	if age := now.Sub(time.Unix(t, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return errors.AddContext(ErrInvalidSignature, "the event is too old")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, sig := range sigs {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
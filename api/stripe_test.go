@@ -0,0 +1,178 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// stripeTestSecret is the webhook secret we sign the test events with.
+	stripeTestSecret = "whsec_test_secret"
+
+	// stripeTestEvent is a trimmed down customer.subscription.updated event.
+	stripeTestEvent = `{
+  "id": "evt_1IXYZ",
+  "object": "event",
+  "type": "customer.subscription.updated",
+  "created": 1611964800,
+  "data": {
+    "object": {
+      "id": "sub_1IXYZ",
+      "object": "subscription",
+      "customer": "cus_JXYZ",
+      "status": "active",
+      "current_period_end": 1614556800,
+      "metadata": {"sub": "695725d4-a345-4e68-919a-7395cb68484c"},
+      "items": {"data": [{"price": {"id": "price_premium20"}}]}
+    }
+  }
+}`
+)
+
+// signStripePayload signs the given payload the same way Stripe does.
+func signStripePayload(payload []byte, secret string, t time.Time) string {
+	ts := fmt.Sprint(t.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(ts + "."))
+	_, _ = mac.Write(payload)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// TestVerifyStripeSignature ensures we only accept recent events signed with
+// our secret.
+func TestVerifyStripeSignature(t *testing.T) {
+	payload := []byte(stripeTestEvent)
+	now := time.Now()
+	tests := []struct {
+		name   string
+		header string
+		valid  bool
+	}{
+		{name: "valid", header: signStripePayload(payload, stripeTestSecret, now), valid: true},
+		{name: "second signature valid", header: "v1=00ff," + signStripePayload(payload, stripeTestSecret, now), valid: true},
+		{name: "wrong secret", header: signStripePayload(payload, "whsec_other", now), valid: false},
+		{name: "too old", header: signStripePayload(payload, stripeTestSecret, now.Add(-10*time.Minute)), valid: false},
+		{name: "in the future", header: signStripePayload(payload, stripeTestSecret, now.Add(10*time.Minute)), valid: false},
+		{name: "no signature", header: fmt.Sprintf("t=%d", now.Unix()), valid: false},
+		{name: "empty", header: "", valid: false},
+	}
+	for _, tt := range tests {
+		err := verifyStripeSignature(payload, tt.header, stripeTestSecret, now)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %t, got error %v", tt.name, tt.valid, err)
+		}
+		if err != nil && !errors.Contains(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", tt.name, err)
+		}
+	}
+	// A tampered payload should fail.
+	header := signStripePayload(payload, stripeTestSecret, now)
+	if err := verifyStripeSignature(append(payload, ' '), header, stripeTestSecret, now); err == nil {
+		t.Error("expected a tampered payload to fail")
+	}
+}
+
+// TestStripeSubscriptionTier ensures we map Stripe subscription events to the
+// right tiers.
+func TestStripeSubscriptionTier(t *testing.T) {
+	oldTiers := StripePriceTiers
+	defer func() { StripePriceTiers = oldTiers }()
+	StripePriceTiers = map[string]int{"price_premium20": database.TierPremium20}
+
+	var ev stripeEvent
+	err := json.Unmarshal([]byte(stripeTestEvent), &ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s stripeSubscription
+	err = json.Unmarshal(ev.Data.Object, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Customer != "cus_JXYZ" || s.Metadata[stripeMetadataSub] != "695725d4-a345-4e68-919a-7395cb68484c" {
+		t.Fatalf("unexpected subscription %+v", s)
+	}
+
+	// An active subscription.
+	tier, until, ok, err := stripeSubscriptionTier(ev.Type, s)
+	if err != nil || !ok || tier != database.TierPremium20 || !until.Equal(time.Unix(1614556800, 0)) {
+		t.Fatalf("unexpected result %d %v %t %v", tier, until, ok, err)
+	}
+	// A subscription which is awaiting payment.
+	s.Status = "past_due"
+	_, _, ok, err = stripeSubscriptionTier(ev.Type, s)
+	if err != nil || ok {
+		t.Fatalf("expected no change for a past due subscription, got %t %v", ok, err)
+	}
+	// A cancelled subscription.
+	s.Status = "canceled"
+	tier, until, ok, err = stripeSubscriptionTier(ev.Type, s)
+	if err != nil || !ok || tier != database.TierFree || !until.IsZero() {
+		t.Fatalf("unexpected result %d %v %t %v", tier, until, ok, err)
+	}
+	// A deleted subscription.
+	s.Status = "active"
+	tier, _, ok, err = stripeSubscriptionTier(stripeEventSubscriptionDeleted, s)
+	if err != nil || !ok || tier != database.TierFree {
+		t.Fatalf("unexpected result %d %t %v", tier, ok, err)
+	}
+	// An unknown price.
+	StripePriceTiers = map[string]int{}
+	_, _, _, err = stripeSubscriptionTier(ev.Type, s)
+	if !errors.Contains(err, ErrUnknownPrice) {
+		t.Fatalf("expected %v, got %v", ErrUnknownPrice, err)
+	}
+}
+
+// TestStripeEventApplies ensures we ignore subscription events which Stripe
+// delivers out of order and events about subscriptions the user replaced.
+func TestStripeEventApplies(t *testing.T) {
+	var ev stripeEvent
+	err := json.Unmarshal([]byte(stripeTestEvent), &ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Unix(ev.Created, 0).UTC()
+	if !created.Equal(time.Date(2021, 1, 30, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected event creation time %v", created)
+	}
+
+	// A user who has never had a subscription.
+	var u database.User
+	if !stripeEventApplies(u, "sub_old", created, database.TierPremium20) {
+		t.Fatal("expected the first event to apply")
+	}
+	// The user subscribed and then cancelled, but the cancellation arrives
+	// before the last update.
+	u.StripeSubscriptionID = "sub_old"
+	u.StripeEventCreatedAt = created.Add(time.Hour)
+	if stripeEventApplies(u, "sub_old", created, database.TierPremium20) {
+		t.Fatal("expected an older update not to revive a cancelled subscription")
+	}
+	// Events created in the same second may both apply.
+	if !stripeEventApplies(u, "sub_old", u.StripeEventCreatedAt, database.TierFree) {
+		t.Fatal("expected an event created at the same time to apply")
+	}
+	// The user replaced their subscription with a new one and then the old
+	// one was deleted.
+	u.StripeSubscriptionID = "sub_new"
+	if stripeEventApplies(u, "sub_old", created.Add(2*time.Hour), database.TierFree) {
+		t.Fatal("expected the deletion of an old subscription not to downgrade the user")
+	}
+	if !stripeEventApplies(u, "sub_new", created.Add(2*time.Hour), database.TierFree) {
+		t.Fatal("expected the deletion of the current subscription to downgrade the user")
+	}
+	// A newer subscription replaces the current one.
+	if !stripeEventApplies(u, "sub_newer", created.Add(2*time.Hour), database.TierPremium5) {
+		t.Fatal("expected a new subscription to apply")
+	}
+}
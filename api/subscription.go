@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// ErrUpgradeNotAllowed is returned when users try to upgrade their own
+	// tier. Upgrades need to go through a payment or an admin.
+	ErrUpgradeNotAllowed = errors.New("users can't upgrade their own tier")
+)
+
+// userPUTHandler changes the current user's tier to the one given by the
+// `tier` param. Users can only downgrade their tier, and the downgrade takes
+// effect at the end of their current billing period. Passing the user's
+// current tier cancels a scheduled downgrade.
+func (api *API) userPUTHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	if err = req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	tier, err := strconv.Atoi(req.Form.Get("tier"))
+	if err != nil {
+		api.WriteError(w, errors.New("invalid parameter 'tier'"), http.StatusBadRequest)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, true)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if tier > u.Tier {
+		api.WriteError(w, ErrUpgradeNotAllowed, http.StatusForbidden)
+		return
+	}
+	if !api.changeSubscription(w, req, u, tier, u.SubscribedUntil, database.SubscriptionSourceUser, false) {
+		return
+	}
+	api.WriteJSON(w, u)
+}
+
+// userSubscriptionChangesHandler returns the current user's subscription
+// history.
+func (api *API) userSubscriptionChangesHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	sub, _, _, err := tokenFromContext(req)
+	if err != nil {
+		api.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	u, err := api.staticDB.UserBySub(req.Context(), sub, true)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.writeSubscriptionChanges(w, req, *u)
+}
+
+// changeSubscription changes the given user's subscription and updates the
+// given user struct. It writes an error response and returns false on
+// failure.
+func (api *API) changeSubscription(w http.ResponseWriter, req *http.Request, u *database.User, tier int, subscribedUntil time.Time, source string, immediate bool) bool {
+	sc, err := api.staticDB.UserChangeSubscription(req.Context(), u, tier, subscribedUntil, source, immediate)
+	if errors.Contains(err, database.ErrInvalidTier) {
+		api.WriteError(w, errors.AddContext(err, "invalid parameter 'tier'"), http.StatusBadRequest)
+		return false
+	}
+	if errors.Contains(err, database.ErrSubscriptionChanged) {
+		api.WriteError(w, err, http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return false
+	}
+	api.staticLogger.Debugf("Subscription of user %s changed by %s from tier %d to %d, %s at %v.", u.ID.Hex(), source, sc.FromTier, sc.ToTier, sc.Status, sc.EffectiveAt)
+	return true
+}
+
+// writeSubscriptionChanges responds with the subscription history of the given
+// user.
+func (api *API) writeSubscriptionChanges(w http.ResponseWriter, req *http.Request, u database.User) {
+	changes, err := api.staticDB.SubscriptionChangesByUser(req.Context(), u)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, changes)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"time"
+)
+
+// keyedThrottle lets an action run at most once per interval for each key,
+// e.g. recording a session or checking a user's usage.
+type keyedThrottle struct {
+	staticInterval time.Duration
+	staticLastRun  *ttlMap
+}
+
+// newKeyedThrottle returns a new keyedThrottle which lets an action run at
+// most once per the given interval for each key.
+func newKeyedThrottle(interval time.Duration) *keyedThrottle {
+	return &keyedThrottle{
+		staticInterval: interval,
+		staticLastRun:  newTTLMap(),
+	}
+}
+
+// managedAllow reports whether the action can run for the given key because it
+// hasn't run in the last interval. If so, it assumes the action is going to
+// run and marks it as run now.
+func (kt *keyedThrottle) managedAllow(key string) bool {
+	return kt.staticLastRun.managedAdd(key, nil, time.Now().Add(kt.staticInterval))
+}
+
+// managedMark marks the action as run now for the given key, regardless of
+// when it last ran.
+func (kt *keyedThrottle) managedMark(key string) {
+	kt.staticLastRun.managedSet(key, nil, time.Now().Add(kt.staticInterval))
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// TestKeyedThrottle ensures keyedThrottle lets an action run at most once per
+// interval for each key.
+func TestKeyedThrottle(t *testing.T) {
+	kt := newKeyedThrottle(time.Minute)
+	if !kt.managedAllow("a") {
+		t.Fatal("expected an unknown key to be allowed")
+	}
+	if kt.managedAllow("a") {
+		t.Fatal("expected a key which ran recently not to be allowed")
+	}
+	if !kt.managedAllow("b") {
+		t.Fatal("expected another unknown key to be allowed")
+	}
+	kt.managedMark("c")
+	if kt.managedAllow("c") {
+		t.Fatal("expected a marked key not to be allowed")
+	}
+	kt.staticLastRun.managedSet("a", nil, time.Now().Add(-time.Second))
+	if !kt.managedAllow("a") {
+		t.Fatal("expected a key which ran long ago to be allowed")
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// ttlMapMaxEntries is the maximum number of entries we keep in a ttlMap.
+	ttlMapMaxEntries = 100000
+)
+
+type (
+	// ttlMap is a size-capped map whose entries expire. We use it for the
+	// in-memory caches which spare us a trip to the DB on each request. When
+	// the map is full we drop the expired entries and, if that's not enough,
+	// all of them. This is always safe because it only means that we'll need
+	// to hit the DB again.
+	ttlMap struct {
+		entries map[string]ttlMapEntry
+		mu      sync.Mutex
+	}
+
+	// ttlMapEntry is a single value in a ttlMap.
+	ttlMapEntry struct {
+		value      interface{}
+		validUntil time.Time
+	}
+)
+
+// newTTLMap returns a new, empty ttlMap.
+func newTTLMap() *ttlMap {
+	return &ttlMap{
+		entries: make(map[string]ttlMapEntry),
+	}
+}
+
+// managedGet returns the value stored under the given key. The second return
+// value reports whether we have a valid value.
+func (m *ttlMap) managedGet(key string) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, exists := m.entries[key]
+	if !exists || time.Now().After(e.validUntil) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// managedSet stores the given value under the given key until validUntil.
+func (m *ttlMap) managedSet(key string, value interface{}, validUntil time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, value, validUntil)
+}
+
+// managedAdd stores the given value under the given key until validUntil,
+// unless the key already holds a valid value. It reports whether it stored
+// the value.
+func (m *ttlMap) managedAdd(key string, value interface{}, validUntil time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, exists := m.entries[key]; exists && !time.Now().After(e.validUntil) {
+		return false
+	}
+	m.set(key, value, validUntil)
+	return true
+}
+
+// set stores the given value under the given key until validUntil, making
+// room for it if the map is full.
+func (m *ttlMap) set(key string, value interface{}, validUntil time.Time) {
+	if _, exists := m.entries[key]; !exists && len(m.entries) >= ttlMapMaxEntries {
+		m.purgeExpired()
+		if len(m.entries) >= ttlMapMaxEntries {
+			m.entries = make(map[string]ttlMapEntry)
+		}
+	}
+	m.entries[key] = ttlMapEntry{
+		value:      value,
+		validUntil: validUntil,
+	}
+}
+
+// purgeExpired removes all expired entries from the map.
+func (m *ttlMap) purgeExpired() {
+	now := time.Now()
+	for key, e := range m.entries {
+		if now.After(e.validUntil) {
+			delete(m.entries, key)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestTTLMap ensures ttlMap expires its entries and doesn't grow beyond
+// ttlMapMaxEntries.
+func TestTTLMap(t *testing.T) {
+	oldMax := ttlMapMaxEntries
+	defer func() { ttlMapMaxEntries = oldMax }()
+	ttlMapMaxEntries = 3
+
+	m := newTTLMap()
+	m.managedSet("a", 1, time.Now().Add(time.Hour))
+	if v, ok := m.managedGet("a"); !ok || v.(int) != 1 {
+		t.Fatalf("unexpected value %v %t", v, ok)
+	}
+	if _, ok := m.managedGet("b"); ok {
+		t.Fatal("expected no value for an unknown key")
+	}
+	// Adding doesn't overwrite valid entries but it does overwrite expired
+	// ones.
+	if m.managedAdd("a", 2, time.Now().Add(time.Hour)) {
+		t.Fatal("expected a valid entry not to be overwritten")
+	}
+	m.managedSet("b", 1, time.Now().Add(-time.Second))
+	if _, ok := m.managedGet("b"); ok {
+		t.Fatal("expected no value for an expired key")
+	}
+	if !m.managedAdd("b", 2, time.Now().Add(time.Hour)) {
+		t.Fatal("expected an expired entry to be overwritten")
+	}
+
+	// When the map is full, the expired entries are dropped first.
+	m.managedSet("c", 1, time.Now().Add(-time.Second))
+	m.managedSet("d", 1, time.Now().Add(time.Hour))
+	if len(m.entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(m.entries))
+	}
+	if _, ok := m.managedGet("a"); !ok {
+		t.Fatal("expected a valid entry to survive")
+	}
+	// If that's not enough, all of them are dropped.
+	for i := 0; i < 2*ttlMapMaxEntries; i++ {
+		m.managedSet(fmt.Sprint(i), 1, time.Now().Add(time.Hour))
+		if len(m.entries) > ttlMapMaxEntries {
+			t.Fatalf("expected at most %d entries, got %d", ttlMapMaxEntries, len(m.entries))
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// ScopeTrackWrite allows registering uploads, downloads and registry
+	// reads and writes on behalf of the user.
+	ScopeTrackWrite = "track:write"
+	// ScopeStatsRead allows reading the user's statistics, uploads and
+	// downloads.
+	ScopeStatsRead = "stats:read"
+	// ScopeUserRead allows reading the user's account data.
+	ScopeUserRead = "user:read"
+
+	// apiKeyEntropy is the number of random bytes in an API key.
+	apiKeyEntropy = 32
+)
+
+var (
+	// ErrAPIKeyNotFound is returned when we can't find the API key in
+	// question.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrInvalidScope is returned when we try to create an API key with a
+	// scope we don't recognise.
+	ErrInvalidScope = errors.New("invalid API key scope")
+
+	// validScopes lists all scopes an API key can have.
+	validScopes = map[string]bool{
+		ScopeTrackWrite: true,
+		ScopeStatsRead:  true,
+		ScopeUserRead:   true,
+	}
+)
+
+// APIKey is a key which allows programmatic access to the API on behalf of the
+// user who created it. We only store a hash of the key, the key itself is only
+// shown once, when it's created.
+type APIKey struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	Name      string             `bson:"name" json:"name"`
+	KeyHash   string             `bson:"key_hash" json:"-"`
+	Scopes    []string           `bson:"scopes" json:"scopes"`
+	CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+// HasScope reports whether the API key has the given scope.
+func (ak APIKey) HasScope(scope string) bool {
+	for _, s := range ak.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyCreate creates a new API key for the given user. It returns the
+// created record and the key itself. The key is not stored anywhere, so this
+// is the only time it can be shown to the user.
+func (db *DB) APIKeyCreate(ctx context.Context, user User, name string, scopes []string) (*APIKey, string, error) {
+	if user.ID.IsZero() {
+		return nil, "", errors.New("invalid user")
+	}
+	if len(scopes) == 0 {
+		return nil, "", errors.AddContext(ErrInvalidScope, "at least one scope is required")
+	}
+	for _, s := range scopes {
+		if !validScopes[s] {
+			return nil, "", errors.AddContext(ErrInvalidScope, s)
+		}
+	}
+	key := hex.EncodeToString(fastrand.Bytes(apiKeyEntropy))
+	ak := APIKey{
+		UserID:    user.ID,
+		Name:      name,
+		KeyHash:   hashAPIKey(key),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	ior, err := db.staticAPIKeys.InsertOne(ctx, ak)
+	if err != nil {
+		return nil, "", errors.AddContext(err, "failed to Insert")
+	}
+	ak.ID = ior.InsertedID.(primitive.ObjectID)
+	return &ak, key, nil
+}
+
+// APIKeyByKey finds the API key record that matches the given key.
+func (db *DB) APIKeyByKey(ctx context.Context, key string) (*APIKey, error) {
+	filter := bson.D{{"key_hash", hashAPIKey(key)}}
+	sr := db.staticAPIKeys.FindOne(ctx, filter)
+	var ak APIKey
+	err := sr.Decode(&ak)
+	if errors.Contains(err, mongo.ErrNoDocuments) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse value from DB")
+	}
+	return &ak, nil
+}
+
+// APIKeysByUser returns all API keys of the given user.
+func (db *DB) APIKeysByUser(ctx context.Context, user User) ([]APIKey, error) {
+	if user.ID.IsZero() {
+		return nil, errors.New("invalid user")
+	}
+	filter := bson.D{{"user_id", user.ID}}
+	c, err := db.staticAPIKeys.Find(ctx, filter)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to Find")
+	}
+	keys := make([]APIKey, 0)
+	err = c.All(ctx, &keys)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse value from DB")
+	}
+	return keys, nil
+}
+
+// APIKeyDelete deletes the API key with the given id. The key needs to belong
+// to the given user.
+func (db *DB) APIKeyDelete(ctx context.Context, user User, id primitive.ObjectID) error {
+	if user.ID.IsZero() {
+		return errors.New("invalid user")
+	}
+	filter := bson.D{
+		{"_id", id},
+		{"user_id", user.ID},
+	}
+	dr, err := db.staticAPIKeys.DeleteOne(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to Delete")
+	}
+	if dr.DeletedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// hashAPIKey returns the hex-encoded hash of the given API key. The keys have
+// enough entropy to make a plain hash safe to store.
+func hashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
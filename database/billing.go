@@ -0,0 +1,79 @@
+package database
+
+import (
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// MaxStatsPeriodsAgo is the number of past billing periods for which we
+	// report usage statistics.
+	MaxStatsPeriodsAgo = 12
+	// MaxStatsRange is the longest window over which we calculate usage
+	// statistics. Longer windows make the aggregations too expensive.
+	MaxStatsRange = 366 * 24 * time.Hour
+
+	// ErrInvalidPeriod is returned when we are asked for the statistics of an
+	// empty or too long window.
+	ErrInvalidPeriod = errors.New("invalid period")
+)
+
+// BillingPeriod is one month of a user's subscription. Users get their
+// bandwidth quota reset at the start of each period. Start is inclusive and
+// End is exclusive. Both are midnight UTC.
+type BillingPeriod struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// CurrentBillingPeriod returns the billing period the user is currently in.
+func CurrentBillingPeriod(subscribedUntil time.Time) BillingPeriod {
+	return BillingPeriodAt(subscribedUntil, time.Now())
+}
+
+// BillingPeriodAt returns the billing period which contains the given time.
+//
+// Periods start on the day of the month on which the user's subscription
+// expires. We don't care if the user is no longer subscribed and their
+// subscription expired 3 months ago, all we care about is the day of the month
+// on which that happened. If they were never subscribed, their periods start
+// on the 1st. In months which are too short, e.g. when the subscription
+// expires on the 31st, the period starts on the last day of the month instead.
+// All days are in UTC, regardless of the time zone of the given times.
+func BillingPeriodAt(subscribedUntil, t time.Time) BillingPeriod {
+	day := subscribedUntil.UTC().Day()
+	t = t.UTC()
+	start := periodStartInMonth(t.Year(), t.Month(), day)
+	if start.After(t) {
+		start = periodStartInMonth(t.Year(), t.Month()-1, day)
+	}
+	return BillingPeriod{
+		Start: start,
+		End:   periodStartInMonth(start.Year(), start.Month()+1, day),
+	}
+}
+
+// BillingPeriodsAgo returns the billing period which is n periods before the
+// one which contains the given time. Zero returns the period which contains
+// the given time.
+func BillingPeriodsAgo(subscribedUntil, t time.Time, n int) BillingPeriod {
+	bp := BillingPeriodAt(subscribedUntil, t)
+	for i := 0; i < n; i++ {
+		bp = BillingPeriodAt(subscribedUntil, bp.Start.Add(-time.Nanosecond))
+	}
+	return bp
+}
+
+// periodStartInMonth returns the start of the billing period which starts on
+// the given day of the given month. The month is normalised, so it can be
+// out of range, e.g. month 0 is December of the previous year. Days which
+// don't exist in the month are clamped to the month's last day.
+func periodStartInMonth(year int, month time.Month, day int) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	lastDay := first.AddDate(0, 1, -1).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBillingPeriodAt ensures billing periods start on the day of the month
+// on which the subscription expires, clamped to the end of short months.
+func TestBillingPeriodAt(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	est := time.FixedZone("EST", -5*60*60)
+	tests := []struct {
+		name            string
+		subscribedUntil time.Time
+		t               time.Time
+		start           time.Time
+		end             time.Time
+	}{
+		{
+			name:            "mid month",
+			subscribedUntil: date(2021, 1, 15),
+			t:               date(2021, 3, 20).Add(time.Hour),
+			start:           date(2021, 3, 15),
+			end:             date(2021, 4, 15),
+		},
+		{
+			name:            "before the day of the month",
+			subscribedUntil: date(2021, 1, 15),
+			t:               date(2021, 3, 10),
+			start:           date(2021, 2, 15),
+			end:             date(2021, 3, 15),
+		},
+		{
+			name:            "on the first day of the period",
+			subscribedUntil: date(2021, 1, 15),
+			t:               date(2021, 3, 15),
+			start:           date(2021, 3, 15),
+			end:             date(2021, 4, 15),
+		},
+		{
+			name:            "just before the end of the period",
+			subscribedUntil: date(2021, 1, 15),
+			t:               date(2021, 3, 15).Add(-time.Nanosecond),
+			start:           date(2021, 2, 15),
+			end:             date(2021, 3, 15),
+		},
+		{
+			name:            "31st in February",
+			subscribedUntil: date(2021, 1, 31),
+			t:               date(2021, 3, 5),
+			start:           date(2021, 2, 28),
+			end:             date(2021, 3, 31),
+		},
+		{
+			name:            "31st in a leap year",
+			subscribedUntil: date(2020, 1, 31),
+			t:               date(2020, 2, 29).Add(time.Hour),
+			start:           date(2020, 2, 29),
+			end:             date(2020, 3, 31),
+		},
+		{
+			name:            "31st in April",
+			subscribedUntil: date(2021, 1, 31),
+			t:               date(2021, 4, 30),
+			start:           date(2021, 4, 30),
+			end:             date(2021, 5, 31),
+		},
+		{
+			name:            "31st at the end of a long month",
+			subscribedUntil: date(2021, 1, 31),
+			t:               date(2021, 3, 31),
+			start:           date(2021, 3, 31),
+			end:             date(2021, 4, 30),
+		},
+		{
+			name:            "across the new year",
+			subscribedUntil: date(2020, 6, 20),
+			t:               date(2021, 1, 5),
+			start:           date(2020, 12, 20),
+			end:             date(2021, 1, 20),
+		},
+		{
+			name:            "never subscribed",
+			subscribedUntil: time.Time{},
+			t:               date(2021, 3, 20),
+			start:           date(2021, 3, 1),
+			end:             date(2021, 4, 1),
+		},
+		{
+			name:            "other time zone",
+			subscribedUntil: time.Date(2021, 1, 14, 20, 0, 0, 0, est),
+			t:               time.Date(2021, 3, 14, 20, 0, 0, 0, est),
+			start:           date(2021, 3, 15),
+			end:             date(2021, 4, 15),
+		},
+	}
+	for _, tt := range tests {
+		bp := BillingPeriodAt(tt.subscribedUntil, tt.t)
+		if !bp.Start.Equal(tt.start) || !bp.End.Equal(tt.end) {
+			t.Errorf("%s: expected %v - %v, got %v - %v", tt.name, tt.start, tt.end, bp.Start, bp.End)
+		}
+		if bp.Start.Location() != time.UTC || bp.End.Location() != time.UTC {
+			t.Errorf("%s: expected the period in UTC, got %v", tt.name, bp)
+		}
+	}
+}
+
+// TestBillingPeriodsAgo ensures we can go back any number of billing periods,
+// including across short months.
+func TestBillingPeriodsAgo(t *testing.T) {
+	subscribedUntil := time.Date(2020, 10, 31, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2021, 3, 31, 12, 0, 0, 0, time.UTC)
+	expected := []time.Time{
+		time.Date(2021, 3, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 11, 30, 0, 0, 0, 0, time.UTC),
+	}
+	for n, start := range expected {
+		bp := BillingPeriodsAgo(subscribedUntil, now, n)
+		if !bp.Start.Equal(start) {
+			t.Errorf("%d periods ago: expected a start of %v, got %v", n, start, bp.Start)
+		}
+		// Each period ends where the next one starts.
+		if n > 0 && !bp.End.Equal(expected[n-1]) {
+			t.Errorf("%d periods ago: expected an end of %v, got %v", n, expected[n-1], bp.End)
+		}
+	}
+}
@@ -35,6 +35,30 @@ var (
 	// dbRegistryWritesCollection defines the name of the "registry_writes"
 	// collection within skynet's database.
 	dbRegistryWritesCollection = "registry_writes"
+	// dbAPIKeysCollection defines the name of the "api_keys" collection within
+	// skynet's database.
+	dbAPIKeysCollection = "api_keys"
+	// dbRevokedTokensCollection defines the name of the "revoked_tokens"
+	// collection within skynet's database.
+	dbRevokedTokensCollection = "revoked_tokens"
+	// dbSessionsCollection defines the name of the "sessions" collection
+	// within skynet's database.
+	dbSessionsCollection = "sessions"
+	// dbSubscriptionChangesCollection defines the name of the
+	// "subscription_changes" collection within skynet's database.
+	dbSubscriptionChangesCollection = "subscription_changes"
+	// dbWebhookEventsCollection defines the name of the "webhook_events"
+	// collection within skynet's database.
+	dbWebhookEventsCollection = "webhook_events"
+	// dbPromoCodesCollection defines the name of the "promo_codes" collection
+	// within skynet's database.
+	dbPromoCodesCollection = "promo_codes"
+	// dbPromoRedemptionsCollection defines the name of the
+	// "promo_redemptions" collection within skynet's database.
+	dbPromoRedemptionsCollection = "promo_redemptions"
+	// dbNotificationsCollection defines the name of the "notifications"
+	// collection within skynet's database.
+	dbNotificationsCollection = "notifications"
 
 	// DefaultPageSize defines the default number of records to return.
 	DefaultPageSize = 10
@@ -70,15 +94,23 @@ var (
 type (
 	// DB represents a MongoDB database connection.
 	DB struct {
-		staticDB             *mongo.Database
-		staticUsers          *mongo.Collection
-		staticSkylinks       *mongo.Collection
-		staticUploads        *mongo.Collection
-		staticDownloads      *mongo.Collection
-		staticRegistryReads  *mongo.Collection
-		staticRegistryWrites *mongo.Collection
-		staticDep            lib.Dependencies
-		staticLogger         *logrus.Logger
+		staticDB                  *mongo.Database
+		staticUsers               *mongo.Collection
+		staticSkylinks            *mongo.Collection
+		staticUploads             *mongo.Collection
+		staticDownloads           *mongo.Collection
+		staticRegistryReads       *mongo.Collection
+		staticRegistryWrites      *mongo.Collection
+		staticAPIKeys             *mongo.Collection
+		staticRevokedTokens       *mongo.Collection
+		staticSessions            *mongo.Collection
+		staticSubscriptionChanges *mongo.Collection
+		staticWebhookEvents       *mongo.Collection
+		staticPromoCodes          *mongo.Collection
+		staticPromoRedemptions    *mongo.Collection
+		staticNotifications       *mongo.Collection
+		staticDep                 lib.Dependencies
+		staticLogger              *logrus.Logger
 	}
 
 	// DBCredentials is a helper struct that binds together all values needed for
@@ -111,14 +143,22 @@ func New(ctx context.Context, creds DBCredentials, logger *logrus.Logger) (*DB,
 		return nil, err
 	}
 	db := &DB{
-		staticDB:             database,
-		staticUsers:          database.Collection(dbUsersCollection),
-		staticSkylinks:       database.Collection(dbSkylinksCollection),
-		staticUploads:        database.Collection(dbUploadsCollection),
-		staticDownloads:      database.Collection(dbDownloadsCollection),
-		staticRegistryReads:  database.Collection(dbRegistryReadsCollection),
-		staticRegistryWrites: database.Collection(dbRegistryWritesCollection),
-		staticLogger:         logger,
+		staticDB:                  database,
+		staticUsers:               database.Collection(dbUsersCollection),
+		staticSkylinks:            database.Collection(dbSkylinksCollection),
+		staticUploads:             database.Collection(dbUploadsCollection),
+		staticDownloads:           database.Collection(dbDownloadsCollection),
+		staticRegistryReads:       database.Collection(dbRegistryReadsCollection),
+		staticRegistryWrites:      database.Collection(dbRegistryWritesCollection),
+		staticAPIKeys:             database.Collection(dbAPIKeysCollection),
+		staticRevokedTokens:       database.Collection(dbRevokedTokensCollection),
+		staticSessions:            database.Collection(dbSessionsCollection),
+		staticSubscriptionChanges: database.Collection(dbSubscriptionChangesCollection),
+		staticWebhookEvents:       database.Collection(dbWebhookEventsCollection),
+		staticPromoCodes:          database.Collection(dbPromoCodesCollection),
+		staticPromoRedemptions:    database.Collection(dbPromoRedemptionsCollection),
+		staticNotifications:       database.Collection(dbNotificationsCollection),
+		staticLogger:              logger,
 	}
 	return db, nil
 }
@@ -162,6 +202,18 @@ func ensureDBSchema(ctx context.Context, db *mongo.Database, log *logrus.Logger)
 				Keys:    bson.D{{"sub", 1}},
 				Options: options.Index().SetName("sub_unique").SetUnique(true),
 			},
+			{
+				Keys:    bson.D{{"pending_tier_at", 1}},
+				Options: options.Index().SetName("pending_tier_at"),
+			},
+			{
+				Keys:    bson.D{{"subscribed_until", 1}},
+				Options: options.Index().SetName("subscribed_until"),
+			},
+			{
+				Keys:    bson.D{{"stripe_customer_id", 1}},
+				Options: options.Index().SetName("stripe_customer_id"),
+			},
 		},
 		dbSkylinksCollection: {
 			{
@@ -201,6 +253,84 @@ func ensureDBSchema(ctx context.Context, db *mongo.Database, log *logrus.Logger)
 				Options: options.Index().SetName("user_id"),
 			},
 		},
+		dbAPIKeysCollection: {
+			{
+				Keys:    bson.D{{"key_hash", 1}},
+				Options: options.Index().SetName("key_hash_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{"user_id", 1}},
+				Options: options.Index().SetName("user_id"),
+			},
+		},
+		dbRevokedTokensCollection: {
+			{
+				Keys:    bson.D{{"jti", 1}},
+				Options: options.Index().SetName("jti_unique").SetUnique(true),
+			},
+			// MongoDB removes the revoked tokens once they expire.
+			// See https://docs.mongodb.com/manual/core/index-ttl/
+			{
+				Keys:    bson.D{{"expires_at", 1}},
+				Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+		dbSessionsCollection: {
+			{
+				Keys:    bson.D{{"session_id", 1}},
+				Options: options.Index().SetName("session_id_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{"user_id", 1}},
+				Options: options.Index().SetName("user_id"),
+			},
+			// MongoDB removes the sessions once they expire.
+			{
+				Keys:    bson.D{{"expires_at", 1}},
+				Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+		dbSubscriptionChangesCollection: {
+			{
+				Keys:    bson.D{{"user_id", 1}},
+				Options: options.Index().SetName("user_id"),
+			},
+		},
+		dbWebhookEventsCollection: {
+			{
+				Keys:    bson.D{{"event_id", 1}},
+				Options: options.Index().SetName("event_id_unique").SetUnique(true),
+			},
+			// Payment providers stop retrying events after a few days, so we
+			// don't need to remember them for longer than that.
+			{
+				Keys:    bson.D{{"received_at", 1}},
+				Options: options.Index().SetName("received_at_ttl").SetExpireAfterSeconds(30 * 24 * 60 * 60),
+			},
+		},
+		dbPromoCodesCollection: {
+			{
+				Keys:    bson.D{{"code", 1}},
+				Options: options.Index().SetName("code_unique").SetUnique(true),
+			},
+		},
+		dbPromoRedemptionsCollection: {
+			{
+				Keys:    bson.D{{"code_id", 1}, {"sub_hash", 1}},
+				Options: options.Index().SetName("code_id_sub_hash_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{"user_id", 1}},
+				Options: options.Index().SetName("user_id"),
+			},
+		},
+		dbNotificationsCollection: {
+			// Each usage notification is only created once per period.
+			{
+				Keys:    bson.D{{"user_id", 1}, {"type", 1}, {"resource", 1}, {"threshold", 1}, {"period_start", 1}},
+				Options: options.Index().SetName("user_id_type_resource_threshold_period_start_unique").SetUnique(true),
+			},
+		},
 	}
 	for collName, models := range schema {
 		coll, err := ensureCollection(ctx, db, collName)
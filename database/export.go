@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type (
+	// ExportWriter is the destination of a user's data export. Each section of
+	// the export is written to a separate file. *zip.Writer implements it.
+	ExportWriter interface {
+		Create(name string) (io.Writer, error)
+	}
+
+	// uploadExport is the representation of an upload in a data export.
+	uploadExport struct {
+		ID        string    `bson:"_id" json:"id"`
+		Skylink   string    `bson:"skylink" json:"skylink"`
+		Name      string    `bson:"name" json:"name"`
+		Size      int64     `bson:"size" json:"size"`
+		Timestamp time.Time `bson:"timestamp" json:"uploadedOn"`
+	}
+
+	// downloadExport is the representation of a download in a data export.
+	// Size is the size of the skylink, while Bytes is the number of bytes
+	// actually downloaded. Bytes is zero for full downloads.
+	downloadExport struct {
+		ID        string    `bson:"_id" json:"id"`
+		Skylink   string    `bson:"skylink" json:"skylink"`
+		Name      string    `bson:"name" json:"name"`
+		Size      int64     `bson:"size" json:"size"`
+		Bytes     int64     `bson:"bytes" json:"bytes"`
+		CreatedAt time.Time `bson:"created_at" json:"downloadedOn"`
+		UpdatedAt time.Time `bson:"updated_at" json:"updatedOn"`
+	}
+
+	// statsExport is the representation of the user's statistics for a single
+	// billing period in a data export.
+	statsExport struct {
+		Period BillingPeriod `json:"period"`
+		Stats  *UserStats    `json:"stats"`
+	}
+)
+
+// UserExport writes all data we hold about the given user to w. The user's
+// statistics, uploads, downloads, registry reads and writes, API keys and
+// sessions are written as newline-delimited JSON, one record per line. Records
+// are streamed from the DB one by one, so the export doesn't need to fit in
+// memory.
+func (db *DB) UserExport(ctx context.Context, u User, w ExportWriter) error {
+	if u.ID.IsZero() {
+		return errors.New("invalid user")
+	}
+	err := exportJSON(w, "user.json", u)
+	if err != nil {
+		return err
+	}
+	err = db.exportStats(ctx, u, w, time.Now())
+	if err != nil {
+		return err
+	}
+
+	matchStage := bson.D{{"$match", bson.D{{"user_id", u.ID}}}}
+	c, err := db.staticUploads.Aggregate(ctx, generateExportPipeline(matchStage))
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch uploads")
+	}
+	err = db.exportNDJSON(ctx, w, "uploads.ndjson", c, func() interface{} { return &uploadExport{} })
+	if err != nil {
+		return err
+	}
+	c, err = db.staticDownloads.Aggregate(ctx, generateExportPipeline(matchStage))
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch downloads")
+	}
+	err = db.exportNDJSON(ctx, w, "downloads.ndjson", c, func() interface{} { return &downloadExport{} })
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{{"user_id", u.ID}}
+	c, err = db.staticRegistryReads.Find(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch registry reads")
+	}
+	err = db.exportNDJSON(ctx, w, "registry_reads.ndjson", c, func() interface{} { return &RegistryRead{} })
+	if err != nil {
+		return err
+	}
+	c, err = db.staticRegistryWrites.Find(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch registry writes")
+	}
+	err = db.exportNDJSON(ctx, w, "registry_writes.ndjson", c, func() interface{} { return &RegistryWrite{} })
+	if err != nil {
+		return err
+	}
+	c, err = db.staticAPIKeys.Find(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch API keys")
+	}
+	err = db.exportNDJSON(ctx, w, "api_keys.ndjson", c, func() interface{} { return &APIKey{} })
+	if err != nil {
+		return err
+	}
+	c, err = db.staticSessions.Find(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch sessions")
+	}
+	err = db.exportNDJSON(ctx, w, "sessions.ndjson", c, func() interface{} { return &Session{} })
+	if err != nil {
+		return err
+	}
+	c, err = db.staticSubscriptionChanges.Find(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch subscription changes")
+	}
+	err = db.exportNDJSON(ctx, w, "subscription_changes.ndjson", c, func() interface{} { return &SubscriptionChange{} })
+	if err != nil {
+		return err
+	}
+	c, err = db.staticPromoRedemptions.Find(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch promo code redemptions")
+	}
+	err = db.exportNDJSON(ctx, w, "promo_redemptions.ndjson", c, func() interface{} { return &PromoRedemption{} })
+	if err != nil {
+		return err
+	}
+	c, err = db.staticNotifications.Find(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch notifications")
+	}
+	return db.exportNDJSON(ctx, w, "notifications.ndjson", c, func() interface{} { return &Notification{} })
+}
+
+// exportJSON writes v as JSON to a new file with the given name.
+func exportJSON(w ExportWriter, name string, v interface{}) error {
+	f, err := w.Create(name)
+	if err != nil {
+		return errors.AddContext(err, "failed to create "+name)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return errors.AddContext(enc.Encode(v), "failed to write "+name)
+}
+
+// exportStats writes the user's statistics for each of their billing periods,
+// see exportPeriods, to a new file, one period per line.
+func (db *DB) exportStats(ctx context.Context, u User, w ExportWriter, now time.Time) error {
+	f, err := w.Create("stats.ndjson")
+	if err != nil {
+		return errors.AddContext(err, "failed to create stats.ndjson")
+	}
+	enc := json.NewEncoder(f)
+	for _, period := range exportPeriods(u, now) {
+		stats, err := db.UserStatsForPeriod(ctx, u, period)
+		if err != nil {
+			return errors.AddContext(err, "failed to fetch user stats")
+		}
+		if err = enc.Encode(statsExport{Period: period, Stats: stats}); err != nil {
+			return errors.AddContext(err, "failed to write stats.ndjson")
+		}
+	}
+	return nil
+}
+
+// exportPeriods returns the user's billing periods, oldest first, from the one
+// in which the user was created up to the one which contains the given time.
+// We don't go back more than MaxStatsPeriodsAgo periods.
+func exportPeriods(u User, now time.Time) []BillingPeriod {
+	var periods []BillingPeriod
+	for n := 0; n <= MaxStatsPeriodsAgo; n++ {
+		period := BillingPeriodsAgo(u.SubscribedUntil, now, n)
+		if n > 0 && !period.End.After(u.CreatedAt) {
+			break
+		}
+		periods = append([]BillingPeriod{period}, periods...)
+	}
+	return periods
+}
+
+// exportNDJSON writes all documents from the given cursor to a new file with
+// the given name, one JSON object per line. Each document is decoded into the
+// value returned by newDoc. The cursor is closed when done.
+func (db *DB) exportNDJSON(ctx context.Context, w ExportWriter, name string, c *mongo.Cursor, newDoc func() interface{}) error {
+	defer func() {
+		if errDef := c.Close(ctx); errDef != nil {
+			db.staticLogger.Traceln("Error on closing DB cursor.", errDef)
+		}
+	}()
+	f, err := w.Create(name)
+	if err != nil {
+		return errors.AddContext(err, "failed to create "+name)
+	}
+	enc := json.NewEncoder(f)
+	for c.Next(ctx) {
+		doc := newDoc()
+		if err = c.Decode(doc); err != nil {
+			return errors.AddContext(err, "failed to decode DB data")
+		}
+		if err = enc.Encode(doc); err != nil {
+			return errors.AddContext(err, "failed to write "+name)
+		}
+	}
+	return errors.AddContext(c.Err(), "failed to read "+name)
+}
+
+// generateExportPipeline returns a pipeline which fetches all records matching
+// the given matchStage, oldest first, and joins them with the `skylinks`
+// collection in order to add the skylink, name and size of each record.
+func generateExportPipeline(matchStage bson.D) mongo.Pipeline {
+	sortStage := bson.D{{"$sort", bson.D{{"_id", 1}}}}
+	lookupStage := bson.D{
+		{"$lookup", bson.D{
+			{"from", "skylinks"},
+			{"localField", "skylink_id"},
+			{"foreignField", "_id"},
+			{"as", "fromSkylinks"},
+		}},
+	}
+	replaceStage := bson.D{
+		{"$replaceRoot", bson.D{
+			{"newRoot", bson.D{
+				{"$mergeObjects", bson.A{
+					bson.D{{"$arrayElemAt", bson.A{"$fromSkylinks", 0}}}, "$$ROOT"},
+				},
+			}},
+		}},
+	}
+	projectStage := bson.D{{"$project", bson.D{{"fromSkylinks", 0}}}}
+	return mongo.Pipeline{matchStage, sortStage, lookupStage, replaceStage, projectStage}
+}
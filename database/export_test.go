@@ -0,0 +1,42 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestExportPeriods ensures we export the stats of all billing periods since
+// the user was created, but no more than MaxStatsPeriodsAgo.
+func TestExportPeriods(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	now := date(2021, 3, 20)
+	u := User{SubscribedUntil: date(2021, 1, 15)}
+
+	// A user created in the current period.
+	u.CreatedAt = date(2021, 3, 16)
+	periods := exportPeriods(u, now)
+	if len(periods) != 1 || !periods[0].Start.Equal(date(2021, 3, 15)) {
+		t.Fatalf("Unexpected periods %v", periods)
+	}
+	// A user created two periods ago, at the very start of the period.
+	u.CreatedAt = date(2021, 1, 15)
+	periods = exportPeriods(u, now)
+	if len(periods) != 3 || !periods[0].Start.Equal(date(2021, 1, 15)) || !periods[2].Start.Equal(date(2021, 3, 15)) {
+		t.Fatalf("Unexpected periods %v", periods)
+	}
+	for i := 1; i < len(periods); i++ {
+		if !periods[i].Start.Equal(periods[i-1].End) {
+			t.Fatalf("Expected consecutive periods, got %v", periods)
+		}
+	}
+	// A user created long ago, or before we recorded creation times.
+	for _, createdAt := range []time.Time{date(2015, 1, 1), {}} {
+		u.CreatedAt = createdAt
+		periods = exportPeriods(u, now)
+		if len(periods) != MaxStatsPeriodsAgo+1 || !periods[len(periods)-1].Start.Equal(date(2021, 3, 15)) {
+			t.Fatalf("Unexpected periods %v", periods)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"github.com/NebulousLabs/skynet-accounts/skynet"
+)
+
+// TierLimits defines what the users of a given tier are allowed to do. All
+// bandwidth and registry allowances are per subscription month.
+type TierLimits struct {
+	Tier              int    `json:"tier"`
+	TierName          string `json:"tierName"`
+	Storage           int64  `json:"storage"`
+	UploadBandwidth   int64  `json:"uploadBandwidth"`
+	DownloadBandwidth int64  `json:"downloadBandwidth"`
+	MaxUploadSize     int64  `json:"maxUploadSize"`
+	RegistryReads     int64  `json:"registryReads"`
+	RegistryWrites    int64  `json:"registryWrites"`
+	// UploadSpeed and DownloadSpeed are the maximum transfer rates of a single
+	// request in bytes per second. Zero means unlimited.
+	UploadSpeed   int64 `json:"uploadSpeed"`
+	DownloadSpeed int64 `json:"downloadSpeed"`
+	// Price is the monthly price of the tier in USD.
+	Price float64 `json:"price"`
+}
+
+// UserLimits defines the limits of all tiers, indexed by tier. Storage and
+// bandwidth are in bytes, as reported by UserStats. Speeds are in bytes per
+// second.
+var UserLimits = []TierLimits{
+	TierReserved: {
+		Tier:     TierReserved,
+		TierName: "reserved",
+	},
+	TierFree: {
+		Tier:              TierFree,
+		TierName:          "free",
+		Storage:           100 * skynet.GiB,
+		UploadBandwidth:   200 * skynet.GiB,
+		DownloadBandwidth: 500 * skynet.GiB,
+		MaxUploadSize:     skynet.GiB,
+		RegistryReads:     100000,
+		RegistryWrites:    10000,
+		UploadSpeed:       10 * skynet.MiB,
+		DownloadSpeed:     40 * skynet.MiB,
+		Price:             0,
+	},
+	TierPremium5: {
+		Tier:              TierPremium5,
+		TierName:          "premium 5",
+		Storage:           skynet.TiB,
+		UploadBandwidth:   2 * skynet.TiB,
+		DownloadBandwidth: 5 * skynet.TiB,
+		MaxUploadSize:     10 * skynet.GiB,
+		RegistryReads:     1000000,
+		RegistryWrites:    100000,
+		UploadSpeed:       20 * skynet.MiB,
+		DownloadSpeed:     80 * skynet.MiB,
+		Price:             5,
+	},
+	TierPremium20: {
+		Tier:              TierPremium20,
+		TierName:          "premium 20",
+		Storage:           4 * skynet.TiB,
+		UploadBandwidth:   8 * skynet.TiB,
+		DownloadBandwidth: 20 * skynet.TiB,
+		MaxUploadSize:     50 * skynet.GiB,
+		RegistryReads:     10000000,
+		RegistryWrites:    1000000,
+		UploadSpeed:       40 * skynet.MiB,
+		DownloadSpeed:     160 * skynet.MiB,
+		Price:             20,
+	},
+	TierPremium80: {
+		Tier:              TierPremium80,
+		TierName:          "premium 80",
+		Storage:           20 * skynet.TiB,
+		UploadBandwidth:   40 * skynet.TiB,
+		DownloadBandwidth: 100 * skynet.TiB,
+		MaxUploadSize:     100 * skynet.GiB,
+		RegistryReads:     50000000,
+		RegistryWrites:    5000000,
+		UploadSpeed:       80 * skynet.MiB,
+		DownloadSpeed:     320 * skynet.MiB,
+		Price:             80,
+	},
+}
+
+// LimitsForTier returns the limits of the given tier. The second return value
+// reports whether the tier exists.
+func LimitsForTier(tier int) (TierLimits, bool) {
+	if tier <= TierReserved || tier >= len(UserLimits) {
+		return TierLimits{}, false
+	}
+	return UserLimits[tier], true
+}
@@ -0,0 +1,41 @@
+package database
+
+import "testing"
+
+// TestUserLimits ensures the tier limits table is consistent.
+func TestUserLimits(t *testing.T) {
+	for i, l := range UserLimits {
+		if l.Tier != i {
+			t.Errorf("expected the limits at index %d to be for tier %d, got %d", i, i, l.Tier)
+		}
+	}
+	// Each tier should allow at least as much as the one before it.
+	for tier := TierFree + 1; tier < len(UserLimits); tier++ {
+		prev, curr := UserLimits[tier-1], UserLimits[tier]
+		if curr.Storage < prev.Storage ||
+			curr.UploadBandwidth < prev.UploadBandwidth ||
+			curr.DownloadBandwidth < prev.DownloadBandwidth ||
+			curr.MaxUploadSize < prev.MaxUploadSize ||
+			curr.RegistryReads < prev.RegistryReads ||
+			curr.RegistryWrites < prev.RegistryWrites ||
+			!speedAtLeast(curr.UploadSpeed, prev.UploadSpeed) ||
+			!speedAtLeast(curr.DownloadSpeed, prev.DownloadSpeed) {
+			t.Errorf("expected tier %d to allow at least as much as tier %d", tier, tier-1)
+		}
+	}
+	if _, ok := LimitsForTier(TierReserved); ok {
+		t.Error("expected the reserved tier to have no limits")
+	}
+	if _, ok := LimitsForTier(len(UserLimits)); ok {
+		t.Error("expected an unknown tier to have no limits")
+	}
+	if l, ok := LimitsForTier(TierPremium5); !ok || l.Tier != TierPremium5 {
+		t.Errorf("unexpected limits for tier %d: %+v", TierPremium5, l)
+	}
+}
+
+// speedAtLeast reports whether speed a is at least as high as speed b, where
+// zero means unlimited.
+func speedAtLeast(a, b int64) bool {
+	return a == 0 || (b != 0 && a >= b)
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// NotificationTypeUsage marks notifications about the user's usage
+	// reaching a threshold of their tier's limits.
+	NotificationTypeUsage = "usage"
+
+	// UsageStorage is the storage used by the user.
+	UsageStorage = "storage"
+	// UsageUploadBandwidth is the upload bandwidth used by the user in the
+	// current subscription month.
+	UsageUploadBandwidth = "upload_bandwidth"
+	// UsageDownloadBandwidth is the download bandwidth used by the user in
+	// the current subscription month.
+	UsageDownloadBandwidth = "download_bandwidth"
+)
+
+// Notification is a message to the user about their account. Usage
+// notifications are created once per resource, threshold and subscription
+// month.
+type Notification struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID   primitive.ObjectID `bson:"user_id" json:"-"`
+	Type     string             `bson:"type" json:"type"`
+	Resource string             `bson:"resource" json:"resource"`
+	// Threshold is the percentage of the limit the user has reached.
+	Threshold int   `bson:"threshold" json:"threshold"`
+	Used      int64 `bson:"used" json:"used"`
+	Limit     int64 `bson:"limit" json:"limit"`
+	// PeriodStart is the start of the subscription month in which the
+	// threshold was reached.
+	PeriodStart time.Time `bson:"period_start" json:"periodStart"`
+	Message     string    `bson:"message" json:"message"`
+	CreatedAt   time.Time `bson:"created_at" json:"createdAt"`
+}
+
+// NotificationsByUser returns all notifications of the given user, most
+// recent first.
+func (db *DB) NotificationsByUser(ctx context.Context, user User) ([]Notification, error) {
+	if user.ID.IsZero() {
+		return nil, errors.New("invalid user")
+	}
+	filter := bson.D{{"user_id", user.ID}}
+	opts := options.Find().SetSort(bson.D{{"created_at", -1}, {"_id", -1}})
+	c, err := db.staticNotifications.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to Find")
+	}
+	ns := make([]Notification, 0)
+	err = c.All(ctx, &ns)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse value from DB")
+	}
+	return ns, nil
+}
+
+// NotificationsEvaluate compares the given user's stats to the limits of
+// their tier and creates a usage notification for each of the given
+// thresholds, in percent, the user has reached. Each notification is created
+// only once per subscription month, so it's safe to call this method
+// repeatedly and concurrently. It returns the notifications it created.
+func (db *DB) NotificationsEvaluate(ctx context.Context, user User, stats UserStats, thresholds []int) ([]Notification, error) {
+	if user.ID.IsZero() {
+		return nil, errors.New("invalid user")
+	}
+	limits, ok := LimitsForTier(user.Tier)
+	if !ok {
+		return nil, ErrInvalidTier
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	created := make([]Notification, 0)
+	for _, n := range usageNotifications(user, limits, stats, thresholds, CurrentBillingPeriod(user.SubscribedUntil).Start) {
+		n.CreatedAt = now
+		filter := bson.D{
+			{"user_id", n.UserID},
+			{"type", n.Type},
+			{"resource", n.Resource},
+			{"threshold", n.Threshold},
+			{"period_start", n.PeriodStart},
+		}
+		opts := options.Update().SetUpsert(true)
+		ur, err := db.staticNotifications.UpdateOne(ctx, filter, bson.M{"$setOnInsert": n}, opts)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to create notification")
+		}
+		if ur.UpsertedCount == 0 {
+			// We've already notified the user.
+			continue
+		}
+		n.ID = ur.UpsertedID.(primitive.ObjectID)
+		created = append(created, n)
+	}
+	return created, nil
+}
+
+// usageNotifications returns a usage notification for each resource and each
+// of the given thresholds, in percent, the user's usage has reached within
+// the subscription month starting at periodStart. Resources without a limit
+// are skipped.
+func usageNotifications(user User, limits TierLimits, stats UserStats, thresholds []int, periodStart time.Time) []Notification {
+	usage := []struct {
+		resource string
+		name     string
+		used     int64
+		limit    int64
+	}{
+		{UsageStorage, "storage", stats.StorageUsed, limits.Storage},
+		{UsageUploadBandwidth, "monthly upload bandwidth", stats.BandwidthUploads, limits.UploadBandwidth},
+		{UsageDownloadBandwidth, "monthly download bandwidth", stats.BandwidthDownloads, limits.DownloadBandwidth},
+	}
+	var ns []Notification
+	for _, u := range usage {
+		if u.limit <= 0 {
+			continue
+		}
+		for _, t := range thresholds {
+			if t <= 0 || u.used*100 < u.limit*int64(t) {
+				continue
+			}
+			ns = append(ns, Notification{
+				UserID:      user.ID,
+				Type:        NotificationTypeUsage,
+				Resource:    u.resource,
+				Threshold:   t,
+				Used:        u.used,
+				Limit:       u.limit,
+				PeriodStart: periodStart,
+				Message:     fmt.Sprintf("You have used %d%% of your %s.", t, u.name),
+			})
+		}
+	}
+	return ns
+}
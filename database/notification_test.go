@@ -0,0 +1,61 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/skynet"
+)
+
+// TestUsageNotifications ensures we notify users about each threshold they
+// have reached and only about those.
+func TestUsageNotifications(t *testing.T) {
+	u := User{Tier: TierFree}
+	limits := UserLimits[TierFree]
+	periodStart := time.Date(2021, 2, 15, 0, 0, 0, 0, time.UTC)
+	thresholds := []int{80, 100}
+
+	// No usage, no notifications.
+	if ns := usageNotifications(u, limits, UserStats{}, thresholds, periodStart); len(ns) != 0 {
+		t.Fatalf("expected no notifications, got %+v", ns)
+	}
+	// Storage right at 80%, upload bandwidth just under 80% and download
+	// bandwidth over 100%.
+	stats := UserStats{
+		StorageUsed:        limits.Storage * 80 / 100,
+		BandwidthUploads:   limits.UploadBandwidth*80/100 - 1,
+		BandwidthDownloads: limits.DownloadBandwidth + skynet.MiB,
+	}
+	ns := usageNotifications(u, limits, stats, thresholds, periodStart)
+	expected := map[string][]int{
+		UsageStorage:           {80},
+		UsageDownloadBandwidth: {80, 100},
+	}
+	got := make(map[string][]int)
+	for _, n := range ns {
+		if n.Type != NotificationTypeUsage || !n.PeriodStart.Equal(periodStart) || n.Message == "" {
+			t.Fatalf("unexpected notification %+v", n)
+		}
+		got[n.Resource] = append(got[n.Resource], n.Threshold)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for r, ts := range expected {
+		if len(got[r]) != len(ts) {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+		for i := range ts {
+			if got[r][i] != ts[i] {
+				t.Fatalf("expected %v, got %v", expected, got)
+			}
+		}
+	}
+	// Invalid thresholds and resources without limits are skipped.
+	if ns = usageNotifications(u, TierLimits{}, stats, thresholds, periodStart); len(ns) != 0 {
+		t.Fatalf("expected no notifications without limits, got %+v", ns)
+	}
+	if ns = usageNotifications(u, limits, stats, []int{0, -10}, periodStart); len(ns) != 0 {
+		t.Fatalf("expected no notifications for invalid thresholds, got %+v", ns)
+	}
+}
@@ -0,0 +1,287 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// SubscriptionSourcePromo marks changes caused by redeeming a promo code.
+	SubscriptionSourcePromo = "promo"
+
+	// promoCodeLen is the length of the promo codes we generate.
+	promoCodeLen = 10
+	// promoCodeAlphabet holds the characters of the promo codes we generate.
+	// It lacks characters which are easily confused, like 0 and O.
+	promoCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	// ErrPromoCodeNotFound is returned when there is no such promo code.
+	ErrPromoCodeNotFound = errors.New("promo code not found")
+	// ErrPromoCodeExists is returned when we try to create a promo code which
+	// already exists.
+	ErrPromoCodeExists = errors.New("promo code already exists")
+	// ErrPromoCodeExpired is returned when the promo code has expired.
+	ErrPromoCodeExpired = errors.New("promo code has expired")
+	// ErrPromoCodeExhausted is returned when the promo code has reached its
+	// maximum number of redemptions.
+	ErrPromoCodeExhausted = errors.New("promo code has been fully redeemed")
+	// ErrPromoCodeRedeemed is returned when the user has already redeemed the
+	// promo code.
+	ErrPromoCodeRedeemed = errors.New("promo code already redeemed")
+	// ErrPromoCodeTierTooLow is returned when the user already has a higher
+	// tier than the one the promo code grants.
+	ErrPromoCodeTierTooLow = errors.New("promo code grants a lower tier than the current one")
+)
+
+type (
+	// PromoCode grants the users who redeem it a tier for a number of months.
+	PromoCode struct {
+		ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+		Code   string             `bson:"code" json:"code"`
+		Tier   int                `bson:"tier" json:"tier"`
+		Months int                `bson:"months" json:"months"`
+		// MaxRedemptions is the number of times the code can be redeemed.
+		// Zero means unlimited.
+		MaxRedemptions int `bson:"max_redemptions" json:"maxRedemptions"`
+		Redemptions    int `bson:"redemptions" json:"redemptions"`
+		// ExpiresAt is the time after which the code can no longer be
+		// redeemed. The zero value means never.
+		ExpiresAt time.Time `bson:"expires_at" json:"expiresAt"`
+		CreatedAt time.Time `bson:"created_at" json:"createdAt"`
+	}
+
+	// PromoRedemption records that a user redeemed a promo code. When the
+	// user deletes their account we keep the redemption but detach it from
+	// them by removing their ID. We keep the hash of their sub, so they can't
+	// redeem the code again with a new account.
+	PromoRedemption struct {
+		ID              primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+		CodeID          primitive.ObjectID `bson:"code_id" json:"-"`
+		Code            string             `bson:"code" json:"code"`
+		UserID          primitive.ObjectID `bson:"user_id,omitempty" json:"userId"`
+		SubHash         string             `bson:"sub_hash" json:"-"`
+		Tier            int                `bson:"tier" json:"tier"`
+		SubscribedUntil time.Time          `bson:"subscribed_until" json:"subscribedUntil"`
+		RedeemedAt      time.Time          `bson:"redeemed_at" json:"redeemedAt"`
+	}
+)
+
+// PromoCodeCreate creates a new promo code. If the code is empty we generate a
+// random one. Codes are case-insensitive.
+func (db *DB) PromoCodeCreate(ctx context.Context, pc PromoCode) (*PromoCode, error) {
+	if _, ok := LimitsForTier(pc.Tier); !ok {
+		return nil, ErrInvalidTier
+	}
+	if pc.Months < 1 {
+		return nil, errors.New("a promo code needs to grant at least one month")
+	}
+	if pc.MaxRedemptions < 0 {
+		return nil, errors.New("the maximum number of redemptions can't be negative")
+	}
+	if pc.Code == "" {
+		pc.Code = generatePromoCode()
+	}
+	pc.Code = normalizePromoCode(pc.Code)
+	pc.ID = primitive.ObjectID{}
+	pc.Redemptions = 0
+	pc.ExpiresAt = pc.ExpiresAt.UTC()
+	// Mongo stores times with millisecond precision.
+	pc.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	filter := bson.M{"code": pc.Code}
+	update := bson.M{"$setOnInsert": pc}
+	opts := options.Update().SetUpsert(true)
+	ur, err := db.staticPromoCodes.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to create promo code")
+	}
+	if ur.UpsertedCount == 0 {
+		return nil, ErrPromoCodeExists
+	}
+	pc.ID = ur.UpsertedID.(primitive.ObjectID)
+	return &pc, nil
+}
+
+// PromoCodes returns all promo codes, most recent first.
+func (db *DB) PromoCodes(ctx context.Context) ([]PromoCode, error) {
+	opts := options.Find().SetSort(bson.D{{"_id", -1}})
+	c, err := db.staticPromoCodes.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to Find")
+	}
+	codes := make([]PromoCode, 0)
+	err = c.All(ctx, &codes)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse value from DB")
+	}
+	return codes, nil
+}
+
+// PromoCodeByCode returns the promo code with the given code.
+func (db *DB) PromoCodeByCode(ctx context.Context, code string) (*PromoCode, error) {
+	sr := db.staticPromoCodes.FindOne(ctx, bson.M{"code": normalizePromoCode(code)})
+	if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
+		return nil, ErrPromoCodeNotFound
+	}
+	if sr.Err() != nil {
+		return nil, errors.AddContext(sr.Err(), "failed to find promo code")
+	}
+	var pc PromoCode
+	err := sr.Decode(&pc)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse value from DB")
+	}
+	return &pc, nil
+}
+
+// PromoRedemptionsByCode returns all redemptions of the given promo code, most
+// recent first.
+func (db *DB) PromoRedemptionsByCode(ctx context.Context, pc PromoCode) ([]PromoRedemption, error) {
+	filter := bson.D{{"code_id", pc.ID}}
+	opts := options.Find().SetSort(bson.D{{"redeemed_at", -1}})
+	c, err := db.staticPromoRedemptions.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to Find")
+	}
+	rs := make([]PromoRedemption, 0)
+	err = c.All(ctx, &rs)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse value from DB")
+	}
+	return rs, nil
+}
+
+// PromoCodeRedeem redeems the given promo code for the given user. The user
+// gets the code's tier for the code's number of months. If they already have
+// that tier, their subscription is extended by that much. Each user can redeem
+// each code once, even if they delete their account and sign up again.
+//
+// We reserve one of the code's redemptions and record the user's redemption
+// before we change the user's subscription, so concurrent redemptions can't
+// exceed the code's limits. If any of the steps fails we undo the previous
+// ones. On success the given user struct is updated.
+func (db *DB) PromoCodeRedeem(ctx context.Context, u *User, code string) (*PromoRedemption, error) {
+	if u.ID.IsZero() {
+		return nil, errors.AddContext(ErrUserNotFound, "user struct not fully initialised")
+	}
+	pc, err := db.PromoCodeByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if u.Tier > pc.Tier {
+		return nil, ErrPromoCodeTierTooLow
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	// Reserve a redemption, as long as the code is valid.
+	filter := bson.M{
+		"_id": pc.ID,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"expires_at": time.Time{}},
+				bson.M{"expires_at": bson.M{"$gt": now}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"max_redemptions": 0},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$redemptions", "$max_redemptions"}}},
+			}},
+		},
+	}
+	ur, err := db.staticPromoCodes.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"redemptions": 1}})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to redeem promo code")
+	}
+	if ur.MatchedCount == 0 {
+		if !pc.ExpiresAt.IsZero() && !pc.ExpiresAt.After(now) {
+			return nil, ErrPromoCodeExpired
+		}
+		return nil, ErrPromoCodeExhausted
+	}
+	// Record the user's redemption. We compute the new subscription
+	// expiration based on the user's current one.
+	until := now
+	if u.Tier == pc.Tier && u.SubscribedUntil.After(now) {
+		until = u.SubscribedUntil
+	}
+	pr := PromoRedemption{
+		CodeID:          pc.ID,
+		Code:            pc.Code,
+		UserID:          u.ID,
+		SubHash:         hashSub(u.Sub),
+		Tier:            pc.Tier,
+		SubscribedUntil: until.AddDate(0, pc.Months, 0).UTC(),
+		RedeemedAt:      now,
+	}
+	filter = bson.M{"code_id": pc.ID, "sub_hash": pr.SubHash}
+	opts := options.Update().SetUpsert(true)
+	ur, err = db.staticPromoRedemptions.UpdateOne(ctx, filter, bson.M{"$setOnInsert": pr}, opts)
+	if err == nil && ur.UpsertedCount == 0 {
+		err = ErrPromoCodeRedeemed
+	}
+	if err != nil {
+		return nil, errors.Compose(err, db.promoCodeRelease(ctx, pc.ID))
+	}
+	pr.ID = ur.UpsertedID.(primitive.ObjectID)
+	// Change the user's subscription.
+	_, err = db.UserChangeSubscription(ctx, u, pr.Tier, pr.SubscribedUntil, SubscriptionSourcePromo, false)
+	if err != nil {
+		_, errDel := db.staticPromoRedemptions.DeleteOne(ctx, bson.M{"_id": pr.ID})
+		return nil, errors.Compose(err, errDel, db.promoCodeRelease(ctx, pc.ID))
+	}
+	return &pr, nil
+}
+
+// promoCodeRelease releases a redemption reserved by PromoCodeRedeem.
+func (db *DB) promoCodeRelease(ctx context.Context, id primitive.ObjectID) error {
+	_, err := db.staticPromoCodes.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"redemptions": -1}})
+	if err != nil {
+		return errors.AddContext(err, "failed to release promo code redemption")
+	}
+	return nil
+}
+
+// promoRedemptionsDetach detaches the user's promo code redemptions from them.
+// We don't delete the redemptions because the codes' redemption counters
+// include them and because they prevent the user from redeeming the same codes
+// again.
+func (db *DB) promoRedemptionsDetach(ctx context.Context, u *User) error {
+	filter := bson.M{"user_id": u.ID}
+	update := bson.M{"$unset": bson.M{"user_id": ""}}
+	_, err := db.staticPromoRedemptions.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to detach the user's promo code redemptions")
+	}
+	return nil
+}
+
+// hashSub returns the hex-encoded hash of the given sub. We use it to
+// recognise users after they have deleted their account without storing
+// their sub.
+func hashSub(sub string) string {
+	h := sha256.Sum256([]byte(sub))
+	return hex.EncodeToString(h[:])
+}
+
+// generatePromoCode returns a new random promo code.
+func generatePromoCode() string {
+	b := make([]byte, promoCodeLen)
+	for i := range b {
+		b[i] = promoCodeAlphabet[fastrand.Intn(len(promoCodeAlphabet))]
+	}
+	return string(b)
+}
+
+// normalizePromoCode returns the form in which we store the given code.
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RevokedToken describes a JWT which was revoked before its expiration, e.g.
+// because the user logged out. MongoDB removes the record once the token
+// expires because at that point the token is no longer valid anyway.
+type RevokedToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	JTI       string             `bson:"jti" json:"jti"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expiresAt"`
+	RevokedAt time.Time          `bson:"revoked_at" json:"revokedAt"`
+}
+
+// TokenRevoke marks the token with the given `jti` as revoked until the given
+// expiration time. Revoking a token more than once is not an error.
+func (db *DB) TokenRevoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return errors.New("invalid jti")
+	}
+	filter := bson.M{"jti": jti}
+	update := bson.M{
+		"$set": bson.M{
+			"expires_at": expiresAt.UTC(),
+		},
+		"$setOnInsert": bson.M{
+			"jti":        jti,
+			"revoked_at": time.Now().UTC(),
+		},
+	}
+	opts := options.Update().SetUpsert(true)
+	_, err := db.staticRevokedTokens.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to revoke token")
+	}
+	return nil
+}
+
+// TokenIsRevoked checks whether the token with the given `jti` has been
+// revoked.
+func (db *DB) TokenIsRevoked(ctx context.Context, jti string) (bool, error) {
+	filter := bson.M{"jti": jti}
+	sr := db.staticRevokedTokens.FindOne(ctx, filter)
+	err := sr.Err()
+	if errors.Contains(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.AddContext(err, "failed to check token revocation")
+	}
+	return true, nil
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrSessionNotFound is returned when we can't find the session in
+	// question.
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// Session describes a Kratos session in which the user has used our service.
+// MongoDB removes the record once the session expires because at that point
+// its tokens are no longer valid anyway.
+type Session struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	SessionID string             `bson:"session_id" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	// JTI is the `jti` of the last token we saw in this session.
+	JTI       string    `bson:"jti" json:"-"`
+	UserAgent string    `bson:"user_agent" json:"userAgent"`
+	IP        string    `bson:"ip" json:"ip"`
+	FirstSeen time.Time `bson:"first_seen" json:"firstSeen"`
+	LastSeen  time.Time `bson:"last_seen" json:"lastSeen"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expiresAt"`
+	RevokedAt time.Time `bson:"revoked_at" json:"-"`
+	// Current is set by the API when the session is the one making the
+	// request. It's not stored in the DB.
+	Current bool `bson:"-" json:"current"`
+}
+
+// SessionTouch records that the given session was seen. It creates the
+// session if it doesn't exist and otherwise updates its token, user agent, IP,
+// last seen and expiration times. The session's user and first seen time never
+// change.
+func (db *DB) SessionTouch(ctx context.Context, s Session) error {
+	if s.SessionID == "" {
+		return errors.New("invalid session id")
+	}
+	if s.UserID.IsZero() {
+		return errors.New("invalid user")
+	}
+	filter := bson.M{"session_id": s.SessionID}
+	update := bson.M{
+		"$set": bson.M{
+			"jti":        s.JTI,
+			"user_agent": s.UserAgent,
+			"ip":         s.IP,
+			"last_seen":  s.LastSeen.UTC(),
+			"expires_at": s.ExpiresAt.UTC(),
+		},
+		"$setOnInsert": bson.M{
+			"user_id":    s.UserID,
+			"first_seen": s.FirstSeen.UTC(),
+			"revoked_at": time.Time{},
+		},
+	}
+	opts := options.Update().SetUpsert(true)
+	_, err := db.staticSessions.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to record session")
+	}
+	return nil
+}
+
+// SessionsByUser returns all sessions of the given user which are neither
+// revoked nor expired, most recently seen first.
+func (db *DB) SessionsByUser(ctx context.Context, user User) ([]Session, error) {
+	if user.ID.IsZero() {
+		return nil, errors.New("invalid user")
+	}
+	filter := bson.D{
+		{"user_id", user.ID},
+		{"revoked_at", time.Time{}},
+		{"expires_at", bson.D{{"$gt", time.Now().UTC()}}},
+	}
+	opts := options.Find().SetSort(bson.D{{"last_seen", -1}})
+	c, err := db.staticSessions.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to Find")
+	}
+	sessions := make([]Session, 0)
+	err = c.All(ctx, &sessions)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse value from DB")
+	}
+	return sessions, nil
+}
+
+// SessionRevoke revokes the session with the given id. The session needs to
+// belong to the given user. Revoking a session more than once is not an error.
+func (db *DB) SessionRevoke(ctx context.Context, user User, sessionID string) error {
+	if user.ID.IsZero() {
+		return errors.New("invalid user")
+	}
+	filter := bson.D{
+		{"session_id", sessionID},
+		{"user_id", user.ID},
+	}
+	update := bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}}
+	ur, err := db.staticSessions.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to revoke session")
+	}
+	if ur.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// SessionIsRevoked checks whether the session with the given id has been
+// revoked. Sessions we haven't seen are not revoked.
+func (db *DB) SessionIsRevoked(ctx context.Context, sessionID string) (bool, error) {
+	filter := bson.D{
+		{"session_id", sessionID},
+		{"revoked_at", bson.D{{"$gt", time.Time{}}}},
+	}
+	sr := db.staticSessions.FindOne(ctx, filter)
+	err := sr.Err()
+	if errors.Contains(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.AddContext(err, "failed to check session revocation")
+	}
+	return true, nil
+}
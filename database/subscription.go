@@ -0,0 +1,317 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// SubscriptionSourceUser marks changes requested by the user.
+	SubscriptionSourceUser = "user"
+	// SubscriptionSourceAdmin marks changes made by an admin.
+	SubscriptionSourceAdmin = "admin"
+	// SubscriptionSourcePayment marks changes driven by our payment provider.
+	SubscriptionSourcePayment = "payment"
+	// SubscriptionSourceScheduled marks scheduled changes which we applied
+	// once they became due.
+	SubscriptionSourceScheduled = "scheduled"
+	// SubscriptionSourceExpiry marks downgrades to the Free tier because the
+	// user's subscription expired.
+	SubscriptionSourceExpiry = "expiry"
+
+	// SubscriptionStatusApplied marks changes which took effect.
+	SubscriptionStatusApplied = "applied"
+	// SubscriptionStatusScheduled marks changes which will take effect at
+	// the end of the user's current billing period.
+	SubscriptionStatusScheduled = "scheduled"
+)
+
+var (
+	// ErrInvalidTier is returned when the requested tier doesn't exist.
+	ErrInvalidTier = errors.New("invalid tier")
+	// ErrSubscriptionChanged is returned when the user's subscription changed
+	// while we were changing it.
+	ErrSubscriptionChanged = errors.New("the user's subscription changed concurrently, please retry")
+)
+
+// SubscriptionChange is a record in the history of changes to a user's tier
+// and subscription expiration.
+type SubscriptionChange struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID              primitive.ObjectID `bson:"user_id" json:"-"`
+	FromTier            int                `bson:"from_tier" json:"fromTier"`
+	ToTier              int                `bson:"to_tier" json:"toTier"`
+	FromSubscribedUntil time.Time          `bson:"from_subscribed_until" json:"fromSubscribedUntil"`
+	ToSubscribedUntil   time.Time          `bson:"to_subscribed_until" json:"toSubscribedUntil"`
+	Source              string             `bson:"source" json:"source"`
+	Status              string             `bson:"status" json:"status"`
+	// EffectiveAt is when the change took or will take effect.
+	EffectiveAt time.Time `bson:"effective_at" json:"effectiveAt"`
+	CreatedAt   time.Time `bson:"created_at" json:"createdAt"`
+}
+
+// UserChangeSubscription changes the user's tier and subscription expiration.
+// Upgrades and changes which keep the tier apply immediately. Downgrades are
+// scheduled for the end of the user's current billing period, unless
+// immediate is true. A change which applies immediately cancels any scheduled
+// downgrade. Every change is recorded in the user's subscription history.
+//
+// The change only succeeds if the user's tier and scheduled change are still
+// the ones in the given user struct. Otherwise it returns
+// ErrSubscriptionChanged. On success the given user struct is updated.
+func (db *DB) UserChangeSubscription(ctx context.Context, u *User, tier int, subscribedUntil time.Time, source string, immediate bool) (*SubscriptionChange, error) {
+	if u.ID.IsZero() {
+		return nil, errors.AddContext(ErrUserNotFound, "user struct not fully initialised")
+	}
+	if _, ok := LimitsForTier(tier); !ok {
+		return nil, ErrInvalidTier
+	}
+	// Mongo stores times with millisecond precision.
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	sc := &SubscriptionChange{
+		UserID:              u.ID,
+		FromTier:            u.Tier,
+		ToTier:              tier,
+		FromSubscribedUntil: u.SubscribedUntil.UTC(),
+		ToSubscribedUntil:   subscribedUntil.UTC(),
+		Source:              source,
+		CreatedAt:           now,
+	}
+	set := bson.M{
+		"subscribed_until":    subscribedUntil.UTC(),
+		"subscription_source": source,
+	}
+	if tier < u.Tier && !immediate {
+		sc.Status = SubscriptionStatusScheduled
+		sc.EffectiveAt = CurrentBillingPeriod(u.SubscribedUntil).End
+		set["pending_tier"] = tier
+		set["pending_tier_at"] = sc.EffectiveAt
+	} else {
+		sc.Status = SubscriptionStatusApplied
+		sc.EffectiveAt = now
+		set["tier"] = tier
+		set["pending_tier"] = TierReserved
+		set["pending_tier_at"] = time.Time{}
+	}
+	var pending interface{} = u.PendingTier
+	if u.PendingTier == TierReserved {
+		// Users created before we started scheduling changes don't have the
+		// field at all.
+		pending = bson.M{"$in": bson.A{TierReserved, nil}}
+	}
+	filter := bson.M{
+		"_id":          u.ID,
+		"tier":         u.Tier,
+		"pending_tier": pending,
+	}
+	err := db.userFindOneAndUpdate(ctx, filter, bson.M{"$set": set}, u)
+	if err != nil {
+		return nil, err
+	}
+	err = db.subscriptionChangeCreate(ctx, sc)
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// SubscriptionChangesByUser returns the user's subscription history, most
+// recent first.
+func (db *DB) SubscriptionChangesByUser(ctx context.Context, user User) ([]SubscriptionChange, error) {
+	if user.ID.IsZero() {
+		return nil, errors.New("invalid user")
+	}
+	filter := bson.D{{"user_id", user.ID}}
+	opts := options.Find().SetSort(bson.D{{"created_at", -1}, {"_id", -1}})
+	c, err := db.staticSubscriptionChanges.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to Find")
+	}
+	changes := make([]SubscriptionChange, 0)
+	err = c.All(ctx, &changes)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse value from DB")
+	}
+	return changes, nil
+}
+
+// SubscriptionsApplyScheduled applies all scheduled tier changes which are
+// due at the given time and returns how many it applied. It's safe to call it
+// concurrently from multiple instances of the service because each change is
+// only applied if it's still scheduled.
+func (db *DB) SubscriptionsApplyScheduled(ctx context.Context, now time.Time) (int, error) {
+	filter := bson.D{
+		{"pending_tier", bson.D{{"$gt", TierReserved}}},
+		{"pending_tier_at", bson.D{{"$lte", now.UTC()}}},
+	}
+	c, err := db.staticUsers.Find(ctx, filter)
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to Find")
+	}
+	var users []User
+	err = c.All(ctx, &users)
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to parse value from DB")
+	}
+	var errs []error
+	applied := 0
+	for _, u := range users {
+		sc := &SubscriptionChange{
+			UserID:              u.ID,
+			FromTier:            u.Tier,
+			ToTier:              u.PendingTier,
+			FromSubscribedUntil: u.SubscribedUntil,
+			ToSubscribedUntil:   u.SubscribedUntil,
+			Source:              SubscriptionSourceScheduled,
+			Status:              SubscriptionStatusApplied,
+			EffectiveAt:         u.PendingTierAt,
+		}
+		filter := bson.M{
+			"_id":             u.ID,
+			"pending_tier":    u.PendingTier,
+			"pending_tier_at": u.PendingTierAt,
+		}
+		ok, err := db.subscriptionApply(ctx, filter, sc)
+		if err != nil {
+			errs = append(errs, errors.AddContext(err, "failed to apply the scheduled change of user "+u.ID.Hex()))
+			continue
+		}
+		if ok {
+			applied++
+		}
+	}
+	return applied, errors.Compose(errs...)
+}
+
+// SubscriptionsExpire moves all users whose subscription expired more than
+// the given grace period before the given time to the Free tier. Users
+// without a subscription expiration are never moved. Subscriptions granted by
+// promo codes get no grace period because there is no payment to wait for, so
+// they end exactly when they expire. It returns the number of users it moved.
+// It's safe to call it concurrently from multiple instances of the service
+// because each user is only moved if their subscription is still the expired
+// one.
+func (db *DB) SubscriptionsExpire(ctx context.Context, now time.Time, grace time.Duration) (int, error) {
+	filter := bson.D{
+		{"tier", bson.D{{"$gt", TierFree}}},
+		{"$or", bson.A{
+			bson.D{
+				{"subscription_source", SubscriptionSourcePromo},
+				{"subscribed_until", bson.D{
+					{"$gt", time.Time{}},
+					{"$lte", now.UTC()},
+				}},
+			},
+			bson.D{
+				{"subscription_source", bson.D{{"$ne", SubscriptionSourcePromo}}},
+				{"subscribed_until", bson.D{
+					{"$gt", time.Time{}},
+					{"$lte", now.UTC().Add(-grace)},
+				}},
+			},
+		}},
+	}
+	c, err := db.staticUsers.Find(ctx, filter)
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to Find")
+	}
+	var users []User
+	err = c.All(ctx, &users)
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to parse value from DB")
+	}
+	var errs []error
+	expired := 0
+	for _, u := range users {
+		effectiveAt := u.SubscribedUntil.Add(grace)
+		if u.SubscriptionSource == SubscriptionSourcePromo {
+			effectiveAt = u.SubscribedUntil
+		}
+		sc := &SubscriptionChange{
+			UserID:              u.ID,
+			FromTier:            u.Tier,
+			ToTier:              TierFree,
+			FromSubscribedUntil: u.SubscribedUntil,
+			ToSubscribedUntil:   u.SubscribedUntil,
+			Source:              SubscriptionSourceExpiry,
+			Status:              SubscriptionStatusApplied,
+			EffectiveAt:         effectiveAt,
+		}
+		filter := bson.M{
+			"_id":              u.ID,
+			"tier":             u.Tier,
+			"subscribed_until": u.SubscribedUntil,
+		}
+		ok, err := db.subscriptionApply(ctx, filter, sc)
+		if err != nil {
+			errs = append(errs, errors.AddContext(err, "failed to expire the subscription of user "+u.ID.Hex()))
+			continue
+		}
+		if ok {
+			expired++
+		}
+	}
+	return expired, errors.Compose(errs...)
+}
+
+// subscriptionApply moves the user matching the given filter to the target
+// tier of the given change, cancels any scheduled change and records the
+// change. It returns false if no user matches the filter anymore, i.e. if
+// someone else already applied the change or changed the user's subscription
+// in the meantime.
+func (db *DB) subscriptionApply(ctx context.Context, filter bson.M, sc *SubscriptionChange) (bool, error) {
+	update := bson.M{"$set": bson.M{
+		"tier":            sc.ToTier,
+		"pending_tier":    TierReserved,
+		"pending_tier_at": time.Time{},
+	}}
+	var u User
+	err := db.userFindOneAndUpdate(ctx, filter, update, &u)
+	if errors.Contains(err, ErrSubscriptionChanged) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	sc.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	err = db.subscriptionChangeCreate(ctx, sc)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// subscriptionChangeCreate records the given subscription change.
+func (db *DB) subscriptionChangeCreate(ctx context.Context, sc *SubscriptionChange) error {
+	ir, err := db.staticSubscriptionChanges.InsertOne(ctx, sc)
+	if err != nil {
+		return errors.AddContext(err, "failed to record the subscription change")
+	}
+	sc.ID = ir.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// userFindOneAndUpdate applies the given update to the user matching the
+// given filter and decodes the updated user into u. It returns
+// ErrSubscriptionChanged if no user matches the filter.
+func (db *DB) userFindOneAndUpdate(ctx context.Context, filter, update interface{}, u *User) error {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	sr := db.staticUsers.FindOneAndUpdate(ctx, filter, update, opts)
+	if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
+		return ErrSubscriptionChanged
+	}
+	if sr.Err() != nil {
+		return errors.AddContext(sr.Err(), "failed to update user")
+	}
+	err := sr.Decode(u)
+	if err != nil {
+		return errors.AddContext(err, "failed to parse value from DB")
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/skynet"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// UsageBucketDay groups usage by day.
+	UsageBucketDay = "day"
+	// UsageBucketHour groups usage by hour.
+	UsageBucketHour = "hour"
+)
+
+var (
+	// MaxHourlyStatsRange is the longest window for which we report hourly
+	// usage. It's long enough to cover a full billing period.
+	MaxHourlyStatsRange = 31 * 24 * time.Hour
+
+	// usageBuckets describes how we group usage records into buckets. The
+	// format is passed to Mongo's $dateToString, which formats the records'
+	// times in UTC. The layout formats the start of a bucket the same way, so
+	// we can match Mongo's results to our buckets.
+	usageBuckets = map[string]struct {
+		format string
+		layout string
+		size   time.Duration
+	}{
+		UsageBucketDay:  {format: "%Y-%m-%d", layout: "2006-01-02", size: 24 * time.Hour},
+		UsageBucketHour: {format: "%Y-%m-%dT%H", layout: "2006-01-02T15", size: time.Hour},
+	}
+)
+
+type (
+	// UsageSeries is a user's usage in the given window, split into
+	// consecutive buckets of the given size, oldest first. Buckets without
+	// any usage are included.
+	UsageSeries struct {
+		Bucket      string        `json:"bucket"`
+		PeriodStart time.Time     `json:"periodStart"`
+		PeriodEnd   time.Time     `json:"periodEnd"`
+		Items       []UsageBucket `json:"items"`
+	}
+
+	// UsageBucket is a user's usage in a single bucket, which starts at Start.
+	// Sizes are in bytes. The registry bandwidth is calculated the same way as
+	// in UserStats.
+	UsageBucket struct {
+		Start              time.Time `json:"start"`
+		NumUploads         int64     `json:"numUploads"`
+		TotalUploadsSize   int64     `json:"totalUploadsSize"`
+		NumDownloads       int64     `json:"numDownloads"`
+		TotalDownloadsSize int64     `json:"totalDownloadsSize"`
+		NumRegReads        int64     `json:"numRegReads"`
+		NumRegWrites       int64     `json:"numRegWrites"`
+		BandwidthRegReads  int64     `json:"bwRegReads"`
+		BandwidthRegWrites int64     `json:"bwRegWrites"`
+	}
+
+	// usageTotals is the number and total size of the records in a bucket, as
+	// returned by the $group stage.
+	usageTotals struct {
+		Bucket string `bson:"_id"`
+		Count  int64  `bson:"count"`
+		Size   int64  `bson:"size"`
+	}
+)
+
+// UserUsageSeries returns the user's usage in the given window, grouped into
+// buckets of the given size - UsageBucketDay or UsageBucketHour. The window
+// can't be longer than MaxStatsRange, or MaxHourlyStatsRange for hourly
+// buckets. The grouping is done by Mongo, so we never load the individual
+// records.
+func (db *DB) UserUsageSeries(ctx context.Context, user User, period BillingPeriod, bucket string) (*UsageSeries, error) {
+	b, ok := usageBuckets[bucket]
+	if !ok {
+		return nil, errors.New("invalid bucket " + bucket)
+	}
+	maxRange := MaxStatsRange
+	if bucket == UsageBucketHour {
+		maxRange = MaxHourlyStatsRange
+	}
+	if !period.End.After(period.Start) {
+		return nil, errors.AddContext(ErrInvalidPeriod, "the period needs to end after it starts")
+	}
+	if period.End.Sub(period.Start) > maxRange {
+		return nil, errors.AddContext(ErrInvalidPeriod, "the period is too long")
+	}
+	period = BillingPeriod{Start: period.Start.UTC(), End: period.End.UTC()}
+
+	uploads, err := db.usageByBucket(ctx, db.staticUploads, uploadsUsagePipeline(user, period, b.format))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to group uploads")
+	}
+	downloads, err := db.usageByBucket(ctx, db.staticDownloads, downloadsUsagePipeline(user, period, b.format))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to group downloads")
+	}
+	reads, err := db.usageByBucket(ctx, db.staticRegistryReads, registryUsagePipeline(user, period, b.format))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to group registry reads")
+	}
+	writes, err := db.usageByBucket(ctx, db.staticRegistryWrites, registryUsagePipeline(user, period, b.format))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to group registry writes")
+	}
+
+	return newUsageSeries(period, bucket, uploads, downloads, reads, writes), nil
+}
+
+// newUsageSeries puts the given totals, keyed by bucket, into consecutive
+// buckets which cover the given period. The first bucket starts at the start
+// of the day or hour which contains the start of the period.
+func newUsageSeries(period BillingPeriod, bucket string, uploads, downloads, reads, writes map[string]usageTotals) *UsageSeries {
+	b := usageBuckets[bucket]
+	series := &UsageSeries{
+		Bucket:      bucket,
+		PeriodStart: period.Start,
+		PeriodEnd:   period.End,
+		Items:       make([]UsageBucket, 0),
+	}
+	for start := period.Start.Truncate(b.size); start.Before(period.End); start = start.Add(b.size) {
+		key := start.Format(b.layout)
+		series.Items = append(series.Items, UsageBucket{
+			Start:              start,
+			NumUploads:         uploads[key].Count,
+			TotalUploadsSize:   uploads[key].Size,
+			NumDownloads:       downloads[key].Count,
+			TotalDownloadsSize: downloads[key].Size,
+			NumRegReads:        reads[key].Count,
+			NumRegWrites:       writes[key].Count,
+			BandwidthRegReads:  reads[key].Count * skynet.PriceBandwidthRegistryRead,
+			BandwidthRegWrites: writes[key].Count * skynet.PriceBandwidthRegistryWrite,
+		})
+	}
+	return series
+}
+
+// usageByBucket runs the given pipeline, which needs to end with
+// usageGroupStage, and returns its results keyed by bucket.
+func (db *DB) usageByBucket(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline) (map[string]usageTotals, error) {
+	c, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.AddContext(err, "DB query failed")
+	}
+	var results []usageTotals
+	err = c.All(ctx, &results)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode DB data")
+	}
+	totals := make(map[string]usageTotals, len(results))
+	for _, r := range results {
+		totals[r.Bucket] = r
+	}
+	return totals, nil
+}
+
+// uploadsUsagePipeline generates a pipeline which groups the user's uploads in
+// the given period into buckets. The size of each upload is the size of the
+// uploaded skyfile.
+func uploadsUsagePipeline(user User, period BillingPeriod, format string) mongo.Pipeline {
+	lookupStage := bson.D{
+		{"$lookup", bson.D{
+			{"from", "skylinks"},
+			{"localField", "skylink_id"},
+			{"foreignField", "_id"},
+			{"as", "skylink_data"},
+		}},
+	}
+	projectStage := bson.D{{"$project", bson.D{
+		{"timestamp", 1},
+		{"size", bson.D{{"$arrayElemAt", bson.A{"$skylink_data.size", 0}}}},
+	}}}
+	return mongo.Pipeline{
+		usageMatchStage(user, period, "timestamp"),
+		lookupStage,
+		projectStage,
+		usageGroupStage("timestamp", format),
+	}
+}
+
+// downloadsUsagePipeline generates a pipeline which groups the user's
+// downloads in the given period into buckets. Same as in userDownloadStats,
+// the size of each download is the number of bytes downloaded, if known, or
+// the size of the skyfile otherwise.
+func downloadsUsagePipeline(user User, period BillingPeriod, format string) mongo.Pipeline {
+	lookupStage := bson.D{
+		{"$lookup", bson.D{
+			{"from", "skylinks"},
+			{"localField", "skylink_id"},
+			{"foreignField", "_id"},
+			{"as", "fromSkylinks"},
+		}},
+	}
+	projectStage := bson.D{{"$project", bson.D{
+		{"created_at", 1},
+		{"size", bson.D{
+			{"$cond", bson.A{
+				bson.D{{"$gt", bson.A{"$bytes", 0}}}, // if
+				"$bytes",                             // then
+				bson.D{{"$arrayElemAt", bson.A{"$fromSkylinks.size", 0}}}, // else
+			}},
+		}},
+	}}}
+	return mongo.Pipeline{
+		usageMatchStage(user, period, "created_at"),
+		lookupStage,
+		projectStage,
+		usageGroupStage("created_at", format),
+	}
+}
+
+// registryUsagePipeline generates a pipeline which groups the user's registry
+// reads or writes in the given period into buckets.
+func registryUsagePipeline(user User, period BillingPeriod, format string) mongo.Pipeline {
+	return mongo.Pipeline{
+		usageMatchStage(user, period, "timestamp"),
+		usageGroupStage("timestamp", format),
+	}
+}
+
+// usageMatchStage selects the user's records whose given time field falls
+// within the given period.
+func usageMatchStage(user User, period BillingPeriod, field string) bson.D {
+	return bson.D{{"$match", bson.D{
+		{"user_id", user.ID},
+		{field, bson.D{{"$gte", period.Start}, {"$lt", period.End}}},
+	}}}
+}
+
+// usageGroupStage groups records into buckets by formatting their given time
+// field with the given format. It counts the records and sums their `size`
+// field, which is zero if missing.
+func usageGroupStage(field, format string) bson.D {
+	return bson.D{{"$group", bson.D{
+		{"_id", bson.D{{"$dateToString", bson.D{
+			{"format", format},
+			{"date", "$" + field},
+		}}}},
+		{"count", bson.D{{"$sum", 1}}},
+		{"size", bson.D{{"$sum", "$size"}}},
+	}}}
+}
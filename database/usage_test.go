@@ -0,0 +1,61 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/skynet"
+)
+
+// TestNewUsageSeries ensures we cover the whole period with consecutive
+// buckets and put each total in the right one.
+func TestNewUsageSeries(t *testing.T) {
+	period := BillingPeriod{
+		Start: time.Date(2021, 2, 27, 15, 30, 0, 0, time.UTC),
+		End:   time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC),
+	}
+	uploads := map[string]usageTotals{
+		"2021-02-28": {Bucket: "2021-02-28", Count: 2, Size: 1024},
+	}
+	reads := map[string]usageTotals{
+		"2021-02-27": {Bucket: "2021-02-27", Count: 5},
+		"2021-03-01": {Bucket: "2021-03-01", Count: 1},
+		// Outside of the period.
+		"2021-03-02": {Bucket: "2021-03-02", Count: 7},
+	}
+	series := newUsageSeries(period, UsageBucketDay, uploads, nil, reads, nil)
+	if len(series.Items) != 3 {
+		t.Fatalf("expected 3 daily buckets, got %d", len(series.Items))
+	}
+	for i, b := range series.Items {
+		expected := time.Date(2021, 2, 27+i, 0, 0, 0, 0, time.UTC)
+		if !b.Start.Equal(expected) {
+			t.Fatalf("expected bucket %d to start at %v, got %v", i, expected, b.Start)
+		}
+	}
+	if b := series.Items[0]; b.NumRegReads != 5 || b.BandwidthRegReads != 5*skynet.PriceBandwidthRegistryRead || b.NumUploads != 0 {
+		t.Fatalf("unexpected first bucket %+v", b)
+	}
+	if b := series.Items[1]; b.NumUploads != 2 || b.TotalUploadsSize != 1024 || b.NumRegReads != 0 {
+		t.Fatalf("unexpected second bucket %+v", b)
+	}
+	if b := series.Items[2]; b.NumRegReads != 1 {
+		t.Fatalf("unexpected third bucket %+v", b)
+	}
+
+	// Hourly buckets.
+	reads = map[string]usageTotals{
+		"2021-02-27T16": {Bucket: "2021-02-27T16", Count: 3},
+	}
+	period.End = time.Date(2021, 2, 27, 18, 0, 0, 0, time.UTC)
+	series = newUsageSeries(period, UsageBucketHour, nil, nil, reads, nil)
+	if len(series.Items) != 3 {
+		t.Fatalf("expected 3 hourly buckets, got %d", len(series.Items))
+	}
+	if b := series.Items[0]; !b.Start.Equal(time.Date(2021, 2, 27, 15, 0, 0, 0, time.UTC)) || b.NumRegReads != 0 {
+		t.Fatalf("unexpected first bucket %+v", b)
+	}
+	if b := series.Items[1]; b.NumRegReads != 3 {
+		t.Fatalf("unexpected second bucket %+v", b)
+	}
+}
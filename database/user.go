@@ -28,6 +28,14 @@ const (
 	TierPremium80
 )
 
+const (
+	// RoleUser is the role of regular users.
+	RoleUser = iota
+	// RoleAdmin is the role of portal operators. Admins have access to the
+	// admin endpoints.
+	RoleAdmin
+)
+
 type (
 	// User represents a Skynet user.
 	User struct {
@@ -37,6 +45,44 @@ type (
 		Sub             string             `bson:"sub" json:"sub"`
 		Tier            int                `bson:"tier" json:"tier"`
 		SubscribedUntil time.Time          `bson:"subscribed_until" json:"subscribedUntil"`
+		Role            int                `bson:"role" json:"role"`
+		Email           string             `bson:"email" json:"email"`
+		FirstName       string             `bson:"first_name" json:"firstName"`
+		LastName        string             `bson:"last_name" json:"lastName"`
+		EmailVerified   bool               `bson:"email_verified" json:"emailVerified"`
+		CreatedAt       time.Time          `bson:"created_at" json:"createdAt"`
+		LastLoginAt     time.Time          `bson:"last_login_at" json:"lastLoginAt"`
+		// PendingTier is the tier to which the user is scheduled to change at
+		// PendingTierAt. TierReserved means there is no scheduled change.
+		PendingTier   int       `bson:"pending_tier" json:"pendingTier"`
+		PendingTierAt time.Time `bson:"pending_tier_at" json:"pendingTierAt"`
+		// SubscriptionSource is the source of the change which last set
+		// SubscribedUntil, e.g. SubscriptionSourcePromo.
+		SubscriptionSource string `bson:"subscription_source" json:"-"`
+		// StripeCustomerID is the id of the user's customer record with our
+		// payment provider.
+		StripeCustomerID string `bson:"stripe_customer_id" json:"-"`
+		// StripeSubscriptionID is the id of the subscription to which the
+		// last subscription event we applied belongs.
+		StripeSubscriptionID string `bson:"stripe_subscription_id" json:"-"`
+		// StripeEventCreatedAt is the time at which the payment provider
+		// created the last subscription event we applied.
+		StripeEventCreatedAt time.Time `bson:"stripe_event_created_at" json:"-"`
+	}
+	// UserIdentity holds the identity traits of a user, as managed by Kratos.
+	UserIdentity struct {
+		Email         string
+		FirstName     string
+		LastName      string
+		EmailVerified bool
+	}
+	// UsersResponseDTO defines the format of a page of users we send as
+	// response to the caller.
+	UsersResponseDTO struct {
+		Items    []User `json:"items"`
+		Offset   int    `json:"offset"`
+		PageSize int    `json:"pageSize"`
+		Count    int    `json:"count"`
 	}
 	// UserStats contains statistical information about the user.
 	UserStats struct {
@@ -51,6 +97,10 @@ type (
 		BandwidthDownloads int64 `json:"bwDownloads"`
 		BandwidthRegReads  int64 `json:"bwRegReads"`
 		BandwidthRegWrites int64 `json:"bwRegWrites"`
+		// PeriodStart and PeriodEnd are the boundaries of the billing period
+		// the stats cover. See BillingPeriod.
+		PeriodStart time.Time `json:"periodStart"`
+		PeriodEnd   time.Time `json:"periodEnd"`
 	}
 )
 
@@ -67,6 +117,59 @@ func (db *DB) UserBySub(ctx context.Context, sub string, create bool) (*User, er
 	return users[0], nil
 }
 
+// UserByStripeCustomerID returns the user with the given Stripe customer id.
+func (db *DB) UserByStripeCustomerID(ctx context.Context, customerID string) (*User, error) {
+	if customerID == "" {
+		return nil, ErrUserNotFound
+	}
+	users, err := db.managedUsersByField(ctx, "stripe_customer_id", customerID)
+	if err != nil {
+		return nil, err
+	}
+	return users[0], nil
+}
+
+// UserSetStripeCustomerID stores the given Stripe customer id on the user.
+func (db *DB) UserSetStripeCustomerID(ctx context.Context, u *User, customerID string) error {
+	filter := bson.M{"_id": u.ID}
+	update := bson.M{"$set": bson.M{"stripe_customer_id": customerID}}
+	_, err := db.staticUsers.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to update")
+	}
+	u.StripeCustomerID = customerID
+	return nil
+}
+
+// UserSetStripeSubscription records that we are applying an event about the
+// given Stripe subscription, which was created at the given time. It returns
+// ErrStaleStripeEvent if we have already applied a more recent event, e.g.
+// because Stripe delivered the events out of order.
+func (db *DB) UserSetStripeSubscription(ctx context.Context, u *User, subscriptionID string, eventCreatedAt time.Time) error {
+	eventCreatedAt = eventCreatedAt.UTC().Truncate(time.Millisecond)
+	filter := bson.M{
+		"_id": u.ID,
+		"$or": bson.A{
+			bson.M{"stripe_event_created_at": bson.M{"$lte": eventCreatedAt}},
+			bson.M{"stripe_event_created_at": bson.M{"$exists": false}},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"stripe_subscription_id":  subscriptionID,
+		"stripe_event_created_at": eventCreatedAt,
+	}}
+	ur, err := db.staticUsers.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to update")
+	}
+	if ur.MatchedCount == 0 {
+		return ErrStaleStripeEvent
+	}
+	u.StripeSubscriptionID = subscriptionID
+	u.StripeEventCreatedAt = eventCreatedAt
+	return nil
+}
+
 // UserByID finds a user by their ID.
 func (db *DB) UserByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
 	filter := bson.D{{"_id", id}}
@@ -95,6 +198,32 @@ func (db *DB) UserByID(ctx context.Context, id primitive.ObjectID) (*User, error
 	return &u, nil
 }
 
+// Users fetches a page of users, ordered by creation, and the total number of
+// users.
+func (db *DB) Users(ctx context.Context, offset, pageSize int) ([]User, int, error) {
+	if err := validateOffsetPageSize(offset, pageSize); err != nil {
+		return nil, 0, err
+	}
+	cnt, err := db.count(ctx, db.staticUsers, bson.D{{"$match", bson.D{}}})
+	if err != nil || cnt == 0 {
+		return []User{}, 0, err
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"_id", 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(pageSize))
+	c, err := db.staticUsers.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "failed to Find")
+	}
+	users := make([]User, 0, pageSize)
+	err = c.All(ctx, &users)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "failed to parse value from DB")
+	}
+	return users, int(cnt), nil
+}
+
 // UserCreate creates a new user in the DB.
 func (db *DB) UserCreate(ctx context.Context, sub string, tier int) (*User, error) {
 	// Check for an existing user with this sub.
@@ -109,6 +238,9 @@ func (db *DB) UserCreate(ctx context.Context, sub string, tier int) (*User, erro
 		ID:   primitive.ObjectID{},
 		Sub:  sub,
 		Tier: tier,
+		Role: RoleUser,
+		// Mongo stores times with millisecond precision.
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
 	}
 	// Insert the user.
 	fields, err := bson.Marshal(u)
@@ -123,21 +255,91 @@ func (db *DB) UserCreate(ctx context.Context, sub string, tier int) (*User, erro
 	return u, nil
 }
 
-// UserStats returns statistical information about the user.
+// UserUpsertIdentity updates the identity traits of the user with the given
+// sub, creating the user on the Free tier if they don't exist. When login is
+// true it also records the current time as the user's last login.
+func (db *DB) UserUpsertIdentity(ctx context.Context, sub string, id UserIdentity, login bool) (*User, error) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	set := bson.M{
+		"email":          id.Email,
+		"first_name":     id.FirstName,
+		"last_name":      id.LastName,
+		"email_verified": id.EmailVerified,
+	}
+	if login {
+		set["last_login_at"] = now
+	}
+	setOnInsert := bson.M{
+		"tier":             TierFree,
+		"subscribed_until": time.Time{},
+		"role":             RoleUser,
+		"created_at":       now,
+		"pending_tier":     TierReserved,
+		"pending_tier_at":  time.Time{},
+	}
+	if !login {
+		setOnInsert["last_login_at"] = time.Time{}
+	}
+	filter := bson.M{"sub": sub}
+	update := bson.M{"$set": set, "$setOnInsert": setOnInsert}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	sr := db.staticUsers.FindOneAndUpdate(ctx, filter, update, opts)
+	if sr.Err() != nil {
+		return nil, errors.AddContext(sr.Err(), "failed to upsert user")
+	}
+	var u User
+	err := sr.Decode(&u)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse value from DB")
+	}
+	return &u, nil
+}
+
+// UserStats returns statistical information about the user in their current
+// billing period.
 func (db *DB) UserStats(ctx context.Context, user User) (*UserStats, error) {
-	return db.userStats(ctx, user)
+	return db.userStats(ctx, user, CurrentBillingPeriod(user.SubscribedUntil))
 }
 
-// UserDelete deletes a user by their ID.
+// UserStatsForPeriod returns statistical information about the user in the
+// given window. The window doesn't need to be a billing period but it can't be
+// longer than MaxStatsRange.
+func (db *DB) UserStatsForPeriod(ctx context.Context, user User, period BillingPeriod) (*UserStats, error) {
+	if !period.End.After(period.Start) {
+		return nil, errors.AddContext(ErrInvalidPeriod, "the period needs to end after it starts")
+	}
+	if period.End.Sub(period.Start) > MaxStatsRange {
+		return nil, errors.AddContext(ErrInvalidPeriod, "the period is too long")
+	}
+	return db.userStats(ctx, user, BillingPeriod{Start: period.Start.UTC(), End: period.End.UTC()})
+}
+
+// UserDelete deletes a user by their ID, together with all of their records in
+// the other collections. We don't use a transaction because transactions
+// require a replica set. Instead, we delete the user first, so concurrent calls
+// which record the user's activity no longer find them and can't add records
+// to them, and only then we sweep the user's records. We sweep them even if
+// the user is already gone, so a failed deletion can safely be retried until
+// it succeeds.
 func (db *DB) UserDelete(ctx context.Context, u *User) error {
 	if u.ID.IsZero() {
 		return errors.AddContext(ErrUserNotFound, "user struct not fully initialised")
 	}
-	filter := bson.D{{"_id", u.ID}}
-	dr, err := db.staticUsers.DeleteOne(ctx, filter)
+	dr, err := db.staticUsers.DeleteOne(ctx, bson.D{{"_id", u.ID}})
 	if err != nil {
 		return errors.AddContext(err, "failed to Delete")
 	}
+	filter := bson.D{{"user_id", u.ID}}
+	for _, coll := range db.userRecordCollections() {
+		_, err = coll.DeleteMany(ctx, filter)
+		if err != nil {
+			return errors.AddContext(err, "failed to delete the user's records from "+coll.Name())
+		}
+	}
+	err = db.promoRedemptionsDetach(ctx, u)
+	if err != nil {
+		return err
+	}
 	if dr.DeletedCount == 0 {
 		return ErrUserNotFound
 	}
@@ -150,7 +352,9 @@ func (db *DB) UserUpdate(ctx context.Context, u *User) error {
 	// Update the user.
 	filter := bson.M{"_id": u.ID}
 	update := bson.M{"$set": bson.M{
-		"tier": u.Tier,
+		"tier":             u.Tier,
+		"subscribed_until": u.SubscribedUntil.UTC(),
+		"role":             u.Role,
 	}}
 	opts := options.Update().SetUpsert(true)
 	_, err := db.staticUsers.UpdateOne(ctx, filter, update, opts)
@@ -160,6 +364,40 @@ func (db *DB) UserUpdate(ctx context.Context, u *User) error {
 	return nil
 }
 
+// UserSetRole changes the user's role. Unlike UserUpdate it leaves the rest of
+// the user's data untouched. On success the given user struct is updated.
+func (db *DB) UserSetRole(ctx context.Context, u *User, role int) error {
+	if u.ID.IsZero() {
+		return errors.AddContext(ErrUserNotFound, "user struct not fully initialised")
+	}
+	ur, err := db.staticUsers.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return errors.AddContext(err, "failed to update role")
+	}
+	if ur.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	u.Role = role
+	return nil
+}
+
+// userRecordCollections returns all collections which hold records that belong
+// to a user, i.e. which reference the user via a `user_id` field. All of those
+// get deleted when the user is deleted. The user's promo code redemptions are
+// not among them because we keep those, see promoRedemptionsDetach.
+func (db *DB) userRecordCollections() []*mongo.Collection {
+	return []*mongo.Collection{
+		db.staticUploads,
+		db.staticDownloads,
+		db.staticRegistryReads,
+		db.staticRegistryWrites,
+		db.staticAPIKeys,
+		db.staticSessions,
+		db.staticSubscriptionChanges,
+		db.staticNotifications,
+	}
+}
+
 // managedUsersByField finds all users that have a given field value.
 // The calling method is responsible for the validation of the value.
 func (db *DB) managedUsersByField(ctx context.Context, fieldName, fieldValue string) ([]*User, error) {
@@ -188,9 +426,13 @@ func (db *DB) managedUsersByField(ctx context.Context, fieldName, fieldValue str
 	return users, nil
 }
 
-// userStats reports statistical information about the user.
-func (db *DB) userStats(ctx context.Context, user User) (*UserStats, error) {
-	stats := UserStats{}
+// userStats reports statistical information about the user in the given
+// billing period.
+func (db *DB) userStats(ctx context.Context, user User, period BillingPeriod) (*UserStats, error) {
+	stats := UserStats{
+		PeriodStart: period.Start,
+		PeriodEnd:   period.End,
+	}
 	var errs []error
 	var errsMux sync.Mutex
 	regErr := func(msg string, e error) {
@@ -199,13 +441,12 @@ func (db *DB) userStats(ctx context.Context, user User) (*UserStats, error) {
 		errs = append(errs, e)
 		errsMux.Unlock()
 	}
-	startOfMonth := monthStart(user.SubscribedUntil)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		n, size, storage, bw, err := db.userUploadStats(ctx, user.ID, startOfMonth)
+		n, size, storage, bw, err := db.userUploadStats(ctx, user.ID, period)
 		if err != nil {
 			regErr("Failed to get user's upload bandwidth used:", err)
 			return
@@ -219,7 +460,7 @@ func (db *DB) userStats(ctx context.Context, user User) (*UserStats, error) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		n, size, bw, err := db.userDownloadStats(ctx, user.ID, startOfMonth)
+		n, size, bw, err := db.userDownloadStats(ctx, user.ID, period)
 		if err != nil {
 			regErr("Failed to get user's download bandwidth used:", err)
 			return
@@ -232,7 +473,7 @@ func (db *DB) userStats(ctx context.Context, user User) (*UserStats, error) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		n, bw, err := db.userRegistryWriteStats(ctx, user.ID, startOfMonth)
+		n, bw, err := db.userRegistryWriteStats(ctx, user.ID, period)
 		if err != nil {
 			regErr("Failed to get user's registry write bandwidth used:", err)
 			return
@@ -244,7 +485,7 @@ func (db *DB) userStats(ctx context.Context, user User) (*UserStats, error) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		n, bw, err := db.userRegistryReadStats(ctx, user.ID, startOfMonth)
+		n, bw, err := db.userRegistryReadStats(ctx, user.ID, period)
 		if err != nil {
 			regErr("Failed to get user's registry read bandwidth used:", err)
 			return
@@ -263,10 +504,10 @@ func (db *DB) userStats(ctx context.Context, user User) (*UserStats, error) {
 
 // userUploadStats reports on the user's uploads - count, total size and total
 // bandwidth used. It uses the total size of the uploaded skyfiles as basis.
-func (db *DB) userUploadStats(ctx context.Context, id primitive.ObjectID, monthStart time.Time) (count int, totalSize int64, storageUsed int64, totalBandwidth int64, err error) {
+func (db *DB) userUploadStats(ctx context.Context, id primitive.ObjectID, period BillingPeriod) (count int, totalSize int64, storageUsed int64, totalBandwidth int64, err error) {
 	matchStage := bson.D{{"$match", bson.D{
 		{"user_id", id},
-		{"timestamp", bson.D{{"$gt", monthStart}}},
+		{"timestamp", bson.D{{"$gte", period.Start}, {"$lt", period.End}}},
 	}}}
 	lookupStage := bson.D{
 		{"$lookup", bson.D{
@@ -326,10 +567,10 @@ func (db *DB) userUploadStats(ctx context.Context, id primitive.ObjectID, monthS
 
 // userDownloadStats reports on the user's downloads - count, total size and
 // total bandwidth used. It uses the actual bandwidth used, as reported by nginx.
-func (db *DB) userDownloadStats(ctx context.Context, id primitive.ObjectID, monthStart time.Time) (count int, totalSize int64, totalBandwidth int64, err error) {
+func (db *DB) userDownloadStats(ctx context.Context, id primitive.ObjectID, period BillingPeriod) (count int, totalSize int64, totalBandwidth int64, err error) {
 	matchStage := bson.D{{"$match", bson.D{
 		{"user_id", id},
-		{"created_at", bson.D{{"$gt", monthStart}}},
+		{"created_at", bson.D{{"$gte", period.Start}, {"$lt", period.End}}},
 	}}}
 	lookupStage := bson.D{
 		{"$lookup", bson.D{
@@ -391,10 +632,10 @@ func (db *DB) userDownloadStats(ctx context.Context, id primitive.ObjectID, mont
 
 // userRegistryWriteStats reports the number of registry writes by the user and
 // the bandwidth used.
-func (db *DB) userRegistryWriteStats(ctx context.Context, userId primitive.ObjectID, monthStart time.Time) (int64, int64, error) {
+func (db *DB) userRegistryWriteStats(ctx context.Context, userId primitive.ObjectID, period BillingPeriod) (int64, int64, error) {
 	matchStage := bson.D{{"$match", bson.D{
 		{"user_id", userId},
-		{"timestamp", bson.D{{"$gt", monthStart}}},
+		{"timestamp", bson.D{{"$gte", period.Start}, {"$lt", period.End}}},
 	}}}
 	writes, err := db.count(ctx, db.staticRegistryWrites, matchStage)
 	if err != nil {
@@ -405,10 +646,10 @@ func (db *DB) userRegistryWriteStats(ctx context.Context, userId primitive.Objec
 
 // userRegistryReadsStats reports the number of registry reads by the user and
 // the bandwidth used.
-func (db *DB) userRegistryReadStats(ctx context.Context, userId primitive.ObjectID, monthStart time.Time) (int64, int64, error) {
+func (db *DB) userRegistryReadStats(ctx context.Context, userId primitive.ObjectID, period BillingPeriod) (int64, int64, error) {
 	matchStage := bson.D{{"$match", bson.D{
 		{"user_id", userId},
-		{"timestamp", bson.D{{"$gt", monthStart}}},
+		{"timestamp", bson.D{{"$gte", period.Start}, {"$lt", period.End}}},
 	}}}
 	reads, err := db.count(ctx, db.staticRegistryReads, matchStage)
 	if err != nil {
@@ -416,19 +657,3 @@ func (db *DB) userRegistryReadStats(ctx context.Context, userId primitive.Object
 	}
 	return reads, reads * skynet.PriceBandwidthRegistryRead, nil
 }
-
-// monthStart returns the start of the user's subscription month.
-// Users get their bandwidth quota reset at the start of the month.
-func monthStart(subscribedUntil time.Time) time.Time {
-	now := time.Now().UTC()
-	// Check how many days are left until the end of the user's subscription
-	// month. Then calculate when the last subscription month started. We don't
-	// care if the user is no longer subscribed and their sub expired 3 months
-	// ago, all we care about here is the day of the month on which that
-	// happened because that is the day from which we count their statistics for
-	// the month. If they were never subscribed we use Jan 1st 1970 for
-	// SubscribedUntil.
-	daysDelta := subscribedUntil.Day() - now.Day()
-	d := now.AddDate(0, -1, daysDelta)
-	return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
-}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrWebhookEventExists is returned when we have already received the
+	// webhook event in question.
+	ErrWebhookEventExists = errors.New("webhook event already received")
+	// ErrStaleStripeEvent is returned when we have already applied a more
+	// recent event about the user's subscription.
+	ErrStaleStripeEvent = errors.New("a more recent subscription event has already been applied")
+)
+
+// WebhookEvent records that we received a webhook event from our payment
+// provider, so we can recognise the provider's retries and process each event
+// only once. MongoDB removes the record once the provider has stopped retrying.
+type WebhookEvent struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	EventID    string             `bson:"event_id" json:"eventId"`
+	Type       string             `bson:"type" json:"type"`
+	ReceivedAt time.Time          `bson:"received_at" json:"receivedAt"`
+}
+
+// WebhookEventCreate records the webhook event with the given id. It returns
+// ErrWebhookEventExists if we have already recorded it.
+func (db *DB) WebhookEventCreate(ctx context.Context, eventID, eventType string) error {
+	if eventID == "" {
+		return errors.New("invalid event id")
+	}
+	filter := bson.M{"event_id": eventID}
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"event_id":    eventID,
+			"type":        eventType,
+			"received_at": time.Now().UTC(),
+		},
+	}
+	opts := options.Update().SetUpsert(true)
+	ur, err := db.staticWebhookEvents.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to record webhook event")
+	}
+	if ur.UpsertedCount == 0 {
+		return ErrWebhookEventExists
+	}
+	return nil
+}
+
+// WebhookEventDelete removes the record of the webhook event with the given
+// id, so the event can be processed again when the provider retries it.
+func (db *DB) WebhookEventDelete(ctx context.Context, eventID string) error {
+	_, err := db.staticWebhookEvents.DeleteOne(ctx, bson.M{"event_id": eventID})
+	if err != nil {
+		return errors.AddContext(err, "failed to delete webhook event")
+	}
+	return nil
+}
@@ -5,11 +5,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/NebulousLabs/skynet-accounts/api"
 	"github.com/NebulousLabs/skynet-accounts/build"
 	"github.com/NebulousLabs/skynet-accounts/database"
 	"github.com/NebulousLabs/skynet-accounts/metafetcher"
+	"github.com/NebulousLabs/skynet-accounts/subscriptions"
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
@@ -23,6 +27,58 @@ const (
 )
 
 var (
+	// envJWKSTTL holds the name of the environment variable which defines
+	// for how long we cache Oathkeeper's JWKS, e.g. "15m".
+	envJWKSTTL = "JWKS_TTL"
+	// envJWTIssuer holds the name of the environment variable which defines
+	// the expected issuer of the JWTs we accept.
+	envJWTIssuer = "JWT_ISSUER"
+	// envJWTAudience holds the name of the environment variable which defines
+	// the expected audience of the JWTs we accept.
+	envJWTAudience = "JWT_AUDIENCE"
+	// envJWTClockSkew holds the name of the environment variable which
+	// defines the clock skew we tolerate when validating JWTs, e.g. "30s".
+	envJWTClockSkew = "JWT_CLOCK_SKEW"
+	// envJWKSFile holds the name of the environment variable which points to
+	// a JWKS file on disk. When set, we use it instead of Oathkeeper's JWKS.
+	envJWKSFile = "JWKS_FILE"
+	// envJWTPublicKeyFile holds the name of the environment variable which
+	// points to a PEM-encoded public key on disk. When set, we use it instead
+	// of Oathkeeper's JWKS.
+	envJWTPublicKeyFile = "JWT_PUBLIC_KEY_FILE"
+	// envCSRFProtection holds the name of the environment variable which
+	// enables CSRF protection for requests authenticated with the JWT cookie.
+	envCSRFProtection = "CSRF_PROTECTION"
+	// envCSRFTrustedOrigins holds the name of the environment variable which
+	// lists the comma-separated origins from which we accept requests
+	// authenticated with the JWT cookie, even without a CSRF token.
+	envCSRFTrustedOrigins = "CSRF_TRUSTED_ORIGINS"
+	// envCORSAllowedOrigins holds the name of the environment variable which
+	// lists the comma-separated origins allowed to make cross-origin requests.
+	envCORSAllowedOrigins = "CORS_ALLOWED_ORIGINS"
+	// envCORSAllowedMethods holds the name of the environment variable which
+	// lists the comma-separated methods allowed in cross-origin requests.
+	envCORSAllowedMethods = "CORS_ALLOWED_METHODS"
+	// envCORSAllowedHeaders holds the name of the environment variable which
+	// lists the comma-separated headers allowed in cross-origin requests.
+	envCORSAllowedHeaders = "CORS_ALLOWED_HEADERS"
+	// envSubscriptionGracePeriod holds the name of the environment variable
+	// which defines for how long after their subscription expires users keep
+	// their tier, e.g. "72h".
+	envSubscriptionGracePeriod = "SUBSCRIPTION_GRACE_PERIOD"
+	// envStripeWebhookSecret holds the name of the environment variable which
+	// holds the secret with which Stripe signs its webhook events.
+	envStripeWebhookSecret = "STRIPE_WEBHOOK_SECRET" // #nosec G101: Potential hardcoded credentials
+	// envStripePriceTiers holds the name of the environment variable which
+	// maps our Stripe prices to tiers, e.g. "price_abc:2,price_def:3".
+	envStripePriceTiers = "STRIPE_PRICE_TIERS"
+	// envUsageNotificationThresholds holds the name of the environment
+	// variable which lists the percentages of their tier's limits at which we
+	// notify the users about their usage, e.g. "80,100".
+	envUsageNotificationThresholds = "USAGE_NOTIFICATION_THRESHOLDS"
+	// envAdminBootstrapSubs holds the name of the environment variable which
+	// lists the subs of the users who are made admins when they log in.
+	envAdminBootstrapSubs = "ADMIN_BOOTSTRAP_SUBS"
 	// envDBHost holds the name of the environment variable for DB host.
 	envDBHost = "SKYNET_DB_HOST"
 	// envDBPort holds the name of the environment variable for DB port.
@@ -84,6 +140,70 @@ func main() {
 	if oaddr := os.Getenv("OATHKEEPER_ADDR"); oaddr != "" {
 		api.OathkeeperAddr = oaddr
 	}
+	if ttl := os.Getenv(envJWKSTTL); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			log.Fatal(errors.New("invalid value of " + envJWKSTTL + ": " + ttl))
+		}
+		api.JWKSTTL = d
+	}
+	if iss := os.Getenv(envJWTIssuer); iss != "" {
+		api.JWTIssuer = iss
+	}
+	if aud := os.Getenv(envJWTAudience); aud != "" {
+		api.JWTAudience = aud
+	}
+	if skew := os.Getenv(envJWTClockSkew); skew != "" {
+		d, err := time.ParseDuration(skew)
+		if err != nil || d < 0 {
+			log.Fatal(errors.New("invalid value of " + envJWTClockSkew + ": " + skew))
+		}
+		api.JWTClockSkew = d
+	}
+	if csrf := os.Getenv(envCSRFProtection); csrf != "" {
+		enabled, err := strconv.ParseBool(csrf)
+		if err != nil {
+			log.Fatal(errors.New("invalid value of " + envCSRFProtection + ": " + csrf))
+		}
+		api.CSRFProtection = enabled
+	}
+	if origins := os.Getenv(envCSRFTrustedOrigins); origins != "" {
+		api.CSRFTrustedOrigins = splitList(origins)
+	}
+	if origins := os.Getenv(envCORSAllowedOrigins); origins != "" {
+		api.CORSAllowedOrigins = splitList(origins)
+	}
+	if methods := os.Getenv(envCORSAllowedMethods); methods != "" {
+		api.CORSAllowedMethods = splitList(methods)
+	}
+	if headers := os.Getenv(envCORSAllowedHeaders); headers != "" {
+		api.CORSAllowedHeaders = splitList(headers)
+	}
+	if grace := os.Getenv(envSubscriptionGracePeriod); grace != "" {
+		d, err := time.ParseDuration(grace)
+		if err != nil || d < 0 {
+			log.Fatal(errors.New("invalid value of " + envSubscriptionGracePeriod + ": " + grace))
+		}
+		subscriptions.GracePeriod = d
+	}
+	api.StripeWebhookSecret = os.Getenv(envStripeWebhookSecret)
+	if prices := os.Getenv(envStripePriceTiers); prices != "" {
+		tiers, err := priceTiers(prices)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid value of "+envStripePriceTiers))
+		}
+		api.StripePriceTiers = tiers
+	}
+	if list := os.Getenv(envUsageNotificationThresholds); list != "" {
+		thresholds, err := usageThresholds(list)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid value of "+envUsageNotificationThresholds))
+		}
+		api.UsageNotificationThresholds = thresholds
+	}
+	if subs := os.Getenv(envAdminBootstrapSubs); subs != "" {
+		api.AdminBootstrapSubs = splitList(subs)
+	}
 
 	ctx := context.Background()
 	logger := logrus.New()
@@ -92,8 +212,13 @@ func main() {
 	if err != nil {
 		log.Fatal(errors.AddContext(err, "failed to connect to the DB"))
 	}
+	auth, err := authenticator()
+	if err != nil {
+		log.Fatal(errors.AddContext(err, "failed to build the authenticator"))
+	}
 	mf := metafetcher.New(ctx, db, portal, logger)
-	server, err := api.New(db, mf, logger)
+	subscriptions.New(ctx, db, logger)
+	server, err := api.New(db, mf, logger, auth)
 	if err != nil {
 		log.Fatal(errors.AddContext(err, "failed to build the API"))
 	}
@@ -101,6 +226,67 @@ func main() {
 	logger.Fatal(http.ListenAndServe(":"+port, server.Router()))
 }
 
+// authenticator returns the authenticator we should use for validating JWTs,
+// based on the environment variables. A nil authenticator means that the API
+// should use Oathkeeper's JWKS.
+func authenticator() (api.Authenticator, error) {
+	if path := os.Getenv(envJWKSFile); path != "" {
+		return api.NewJWKSFileAuthenticator(path)
+	}
+	if path := os.Getenv(envJWTPublicKeyFile); path != "" {
+		return api.NewStaticKeyAuthenticatorFromFile(path)
+	}
+	return nil, nil
+}
+
+// splitList splits the given comma-separated list and trims its items. Empty
+// items are dropped.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// priceTiers parses the given comma-separated list of `price:tier` pairs.
+func priceTiers(list string) (map[string]int, error) {
+	tiers := make(map[string]int)
+	for _, item := range splitList(list) {
+		i := strings.LastIndex(item, ":")
+		if i < 1 {
+			return nil, errors.New("invalid price " + item)
+		}
+		tier, err := strconv.Atoi(item[i+1:])
+		if err != nil {
+			return nil, errors.New("invalid tier for price " + item)
+		}
+		if _, ok := database.LimitsForTier(tier); !ok {
+			return nil, errors.New("invalid tier for price " + item)
+		}
+		tiers[strings.TrimSpace(item[:i])] = tier
+	}
+	return tiers, nil
+}
+
+// usageThresholds parses a comma-separated list of percentages, e.g.
+// "80,100". Zeros are skipped, so "0" disables the usage notifications.
+func usageThresholds(list string) ([]int, error) {
+	var thresholds []int
+	for _, item := range splitList(list) {
+		t, err := strconv.Atoi(item)
+		if err != nil || t < 0 {
+			return nil, errors.New("invalid threshold " + item)
+		}
+		if t > 0 {
+			thresholds = append(thresholds, t)
+		}
+	}
+	return thresholds, nil
+}
+
 // logLevel returns the desires log level.
 func logLevel() logrus.Level {
 	switch debugEnv, _ := os.LookupEnv(envLogLevel); debugEnv {
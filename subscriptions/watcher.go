@@ -0,0 +1,85 @@
+package subscriptions
+
+import (
+	"context"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// CheckInterval defines how often we check for scheduled subscription
+	// changes which are due and for expired subscriptions. Both take effect
+	// with a delay of at most this much.
+	CheckInterval = 10 * time.Minute
+	// GracePeriod defines for how long after their subscription expires users
+	// keep their tier, e.g. in order to give the payment provider time to
+	// renew it. It doesn't apply to subscriptions granted by promo codes. The
+	// point of this var is to be overridable via .env.
+	GracePeriod = 3 * 24 * time.Hour
+)
+
+// Watcher is a background task which periodically applies the scheduled
+// subscription changes once they become due and moves the users whose
+// subscription expired to the Free tier.
+type Watcher struct {
+	db     *database.DB
+	logger *logrus.Logger
+}
+
+// New returns a new Watcher instance and starts its background loop. The loop
+// stops when the given context is cancelled.
+func New(ctx context.Context, db *database.DB, logger *logrus.Logger) *Watcher {
+	if logger == nil {
+		logger = logrus.New()
+	}
+	w := Watcher{
+		db:     db,
+		logger: logger,
+	}
+
+	go w.threadedWatch(ctx)
+
+	return &w
+}
+
+// threadedWatch applies the due subscription changes and expires the lapsed
+// subscriptions every CheckInterval.
+func (w *Watcher) threadedWatch(ctx context.Context) {
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+	for {
+		w.applyScheduled(ctx)
+		w.expire(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyScheduled applies all scheduled subscription changes which are due.
+func (w *Watcher) applyScheduled(ctx context.Context) {
+	n, err := w.db.SubscriptionsApplyScheduled(ctx, time.Now().UTC())
+	if err != nil {
+		w.logger.Warnln("Failed to apply scheduled subscription changes:", err)
+	}
+	if n > 0 {
+		w.logger.Debugf("Applied %d scheduled subscription changes.", n)
+	}
+}
+
+// expire moves all users whose subscription expired more than GracePeriod ago
+// to the Free tier.
+func (w *Watcher) expire(ctx context.Context) {
+	n, err := w.db.SubscriptionsExpire(ctx, time.Now().UTC(), GracePeriod)
+	if err != nil {
+		w.logger.Warnln("Failed to expire subscriptions:", err)
+	}
+	if n > 0 {
+		w.logger.Debugf("Moved %d users with expired subscriptions to the Free tier.", n)
+	}
+}
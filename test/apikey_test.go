@@ -0,0 +1,82 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestAPIKey ensures we can create, find, list and delete API keys.
+func TestAPIKey(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a test user.
+	sub := string(fastrand.Bytes(userSubLen))
+	u, err := db.UserCreate(ctx, sub, database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u)
+
+	// Creating a key with an invalid scope should fail.
+	_, _, err = db.APIKeyCreate(ctx, *u, "invalid", []string{"not-a-scope"})
+	if !errors.Contains(err, database.ErrInvalidScope) {
+		t.Fatalf("Expected error %v, got %v", database.ErrInvalidScope, err)
+	}
+	// Creating a key without scopes should fail.
+	_, _, err = db.APIKeyCreate(ctx, *u, "no scopes", nil)
+	if !errors.Contains(err, database.ErrInvalidScope) {
+		t.Fatalf("Expected error %v, got %v", database.ErrInvalidScope, err)
+	}
+
+	// Create a valid key.
+	ak, key, err := db.APIKeyCreate(ctx, *u, "test key", []string{database.ScopeTrackWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == "" || ak.KeyHash == key {
+		t.Fatal("Expected a non-empty key which differs from its stored hash.")
+	}
+	// Find it by key.
+	ak1, err := db.APIKeyByKey(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ak1.ID != ak.ID || ak1.UserID != u.ID {
+		t.Fatalf("Expected to find key %v of user %v, got key %v of user %v", ak.ID, u.ID, ak1.ID, ak1.UserID)
+	}
+	if !ak1.HasScope(database.ScopeTrackWrite) || ak1.HasScope(database.ScopeStatsRead) {
+		t.Fatalf("Unexpected scopes %v", ak1.Scopes)
+	}
+	// List the user's keys.
+	keys, err := db.APIKeysByUser(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].ID != ak.ID {
+		t.Fatalf("Expected exactly one key with id %v, got %v", ak.ID, keys)
+	}
+	// Delete the key and make sure it can't be used anymore.
+	err = db.APIKeyDelete(ctx, *u, ak.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.APIKeyByKey(ctx, key)
+	if !errors.Contains(err, database.ErrAPIKeyNotFound) {
+		t.Fatalf("Expected error %v, got %v", database.ErrAPIKeyNotFound, err)
+	}
+	err = db.APIKeyDelete(ctx, *u, ak.ID)
+	if !errors.Contains(err, database.ErrAPIKeyNotFound) {
+		t.Fatalf("Expected error %v, got %v", database.ErrAPIKeyNotFound, err)
+	}
+}
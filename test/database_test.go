@@ -4,10 +4,12 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/NebulousLabs/skynet-accounts/database"
 
 	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -114,6 +116,159 @@ func TestDatabase_UserUpdate(t *testing.T) {
 	if u1.Tier != database.TierPremium5 {
 		t.Fatalf("Expected tier '%d', got '%d'", database.TierPremium5, u1.Tier)
 	}
+
+	// Test changing the user's role.
+	if u1.Role != database.RoleUser {
+		t.Fatalf("Expected role '%d', got '%d'", database.RoleUser, u1.Role)
+	}
+	u.Role = database.RoleAdmin
+	err = db.UserUpdate(ctx, u)
+	if err != nil {
+		t.Fatal("Failed to update user:", err)
+	}
+	u1, err = db.UserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal("Failed to load user:", err)
+	}
+	if u1.Role != database.RoleAdmin {
+		t.Fatalf("Expected role '%d', got '%d'", database.RoleAdmin, u1.Role)
+	}
+
+	// Test changing the user's subscription expiration. Mongo stores times
+	// with millisecond precision, so we use a round value.
+	u.SubscribedUntil = time.Now().UTC().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	err = db.UserUpdate(ctx, u)
+	if err != nil {
+		t.Fatal("Failed to update user:", err)
+	}
+	u1, err = db.UserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal("Failed to load user:", err)
+	}
+	if !u1.SubscribedUntil.Equal(u.SubscribedUntil) {
+		t.Fatalf("Expected subscribed until '%v', got '%v'", u.SubscribedUntil, u1.SubscribedUntil)
+	}
+}
+
+// TestDatabase_UserSetRole ensures UserSetRole changes the user's role and
+// nothing else.
+func TestDatabase_UserSetRole(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := db.UserCreate(ctx, string(fastrand.Bytes(userSubLen)), database.TierPremium5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u)
+	err = db.UserSetRole(ctx, u, database.RoleAdmin)
+	if err != nil {
+		t.Fatal("Failed to set role:", err)
+	}
+	if u.Role != database.RoleAdmin {
+		t.Fatalf("Expected role '%d', got '%d'", database.RoleAdmin, u.Role)
+	}
+	u1, err := db.UserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal("Failed to load user:", err)
+	}
+	if u1.Role != database.RoleAdmin || u1.Tier != database.TierPremium5 {
+		t.Fatalf("Expected role '%d' and tier '%d', got '%d' and '%d'", database.RoleAdmin, database.TierPremium5, u1.Role, u1.Tier)
+	}
+	// Unknown users can't get a role.
+	err = db.UserSetRole(ctx, &database.User{ID: primitive.NewObjectID()}, database.RoleAdmin)
+	if !errors.Contains(err, database.ErrUserNotFound) {
+		t.Fatalf("Expected %v, got %v", database.ErrUserNotFound, err)
+	}
+}
+
+// TestDatabase_Users ensures Users pages through all users.
+func TestDatabase_Users(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, total, err := db.Users(ctx, 0, database.DefaultPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Add two users.
+	for i := 0; i < 2; i++ {
+		u, err := db.UserCreate(ctx, string(fastrand.Bytes(userSubLen)), database.TierFree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func(user *database.User) {
+			_ = db.UserDelete(ctx, user)
+		}(u)
+	}
+
+	users, total1, err := db.Users(ctx, total, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total1 != total+2 {
+		t.Fatalf("Expected %d users, got %d", total+2, total1)
+	}
+	if len(users) != 1 {
+		t.Fatalf("Expected a page of 1 user, got %d", len(users))
+	}
+	_, _, err = db.Users(ctx, -1, 1)
+	if err == nil {
+		t.Fatal("Expected an error for a negative offset.")
+	}
+}
+
+// TestDatabase_UserUpsertIdentity ensures UserUpsertIdentity creates missing
+// users and updates the identity traits of existing ones.
+func TestDatabase_UserUpsertIdentity(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := string(fastrand.Bytes(userSubLen))
+	id := database.UserIdentity{
+		Email:     "user@siasky.net",
+		FirstName: "First",
+		LastName:  "Last",
+	}
+	// Test creating a user.
+	u, err := db.UserUpsertIdentity(ctx, sub, id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u)
+	if u.Sub != sub || u.Tier != database.TierFree || u.Email != id.Email || u.FirstName != id.FirstName || u.LastName != id.LastName {
+		t.Fatalf("Unexpected user %+v", u)
+	}
+	if u.CreatedAt.IsZero() || !u.LastLoginAt.IsZero() {
+		t.Fatalf("Unexpected creation or login time %+v", u)
+	}
+
+	// Test updating the user on login.
+	id.Email = "other@siasky.net"
+	id.EmailVerified = true
+	u1, err := db.UserUpsertIdentity(ctx, sub, id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u1.ID != u.ID || !u1.CreatedAt.Equal(u.CreatedAt) {
+		t.Fatalf("Expected the same user, got %+v and %+v", u, u1)
+	}
+	if u1.Email != id.Email || !u1.EmailVerified || u1.LastLoginAt.IsZero() {
+		t.Fatalf("Unexpected user %+v", u1)
+	}
 }
 
 // TestDatabase_UserDelete ensures UserDelete works as expected.
@@ -141,6 +296,27 @@ func TestDatabase_UserDelete(t *testing.T) {
 	if fu == nil {
 		t.Fatal("expected to find a user but didn't")
 	}
+	// Give the user some records.
+	skylink, err := createTestUpload(ctx, db, u, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DownloadCreate(ctx, *u, *skylink, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.RegistryReadCreate(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.RegistryWriteCreate(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = db.APIKeyCreate(ctx, *u, "test", []string{database.ScopeStatsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
 	// Delete the user.
 	err = db.UserDelete(ctx, u)
 	if err != nil {
@@ -151,6 +327,26 @@ func TestDatabase_UserDelete(t *testing.T) {
 	if !errors.Contains(err, database.ErrUserNotFound) {
 		t.Fatal(err)
 	}
+	// Make sure the user's records are gone as well.
+	stats, err := db.UserStats(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.NumUploads != 0 || stats.NumDownloads != 0 || stats.NumRegReads != 0 || stats.NumRegWrites != 0 {
+		t.Fatalf("Expected the user's records to be deleted, got %+v", stats)
+	}
+	keys, err := db.APIKeysByUser(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("Expected the user's API keys to be deleted, got %d", len(keys))
+	}
+	// Deleting the user again should report that they don't exist.
+	err = db.UserDelete(ctx, u)
+	if !errors.Contains(err, database.ErrUserNotFound) {
+		t.Fatalf("Expected error ErrUserNotFound, got %v", err)
+	}
 }
 
 // DBTestCredentials sets the environment variables to what we have defined in Makefile.
@@ -0,0 +1,124 @@
+package test
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestUserExport ensures UserExport writes all of the user's data.
+func TestUserExport(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a test user with some records.
+	sub := string(fastrand.Bytes(userSubLen))
+	u, err := db.UserCreate(ctx, sub, database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u)
+	skylink, err := createTestUpload(ctx, db, u, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = createTestUpload(ctx, db, u, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DownloadCreate(ctx, *u, *skylink, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.RegistryReadCreate(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	err = db.UserExport(ctx, *u, zw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Count the records in each file.
+	lines := make(map[string]int)
+	var stats struct {
+		Period database.BillingPeriod `json:"period"`
+		Stats  database.UserStats     `json:"stats"`
+	}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name == "user.json" {
+			var eu database.User
+			err = json.NewDecoder(r).Decode(&eu)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if eu.Sub != u.Sub {
+				t.Fatalf("Expected sub %s, got %s", u.Sub, eu.Sub)
+			}
+		}
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			lines[f.Name]++
+			if f.Name == "stats.ndjson" {
+				err = json.Unmarshal(s.Bytes(), &stats)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		_ = r.Close()
+	}
+	expected := map[string]int{
+		"stats.ndjson":                1,
+		"uploads.ndjson":              2,
+		"downloads.ndjson":            1,
+		"registry_reads.ndjson":       1,
+		"registry_writes.ndjson":      0,
+		"api_keys.ndjson":             0,
+		"sessions.ndjson":             0,
+		"subscription_changes.ndjson": 0,
+		"promo_redemptions.ndjson":    0,
+		"notifications.ndjson":        0,
+	}
+	for name, n := range expected {
+		if lines[name] != n {
+			t.Errorf("Expected %d records in %s, got %d", n, name, lines[name])
+		}
+	}
+	// The user was created in the current period, so we expect only its
+	// stats.
+	current := database.CurrentBillingPeriod(u.SubscribedUntil)
+	if !stats.Period.Start.Equal(current.Start) || !stats.Period.End.Equal(current.End) {
+		t.Fatalf("Expected period %v, got %v", current, stats.Period)
+	}
+	if stats.Stats.NumUploads != 2 || stats.Stats.NumDownloads != 1 || stats.Stats.NumRegReads != 1 {
+		t.Fatalf("Unexpected stats %+v", stats.Stats)
+	}
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestNotificationsEvaluate ensures we notify users about their usage once
+// per threshold and subscription month.
+func TestNotificationsEvaluate(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a test user.
+	u, err := db.UserCreate(ctx, string(fastrand.Bytes(userSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u)
+	limits := database.UserLimits[database.TierFree]
+	thresholds := []int{80, 100}
+
+	// Reach 80% of the storage limit.
+	stats := database.UserStats{StorageUsed: limits.Storage * 85 / 100}
+	ns, err := db.NotificationsEvaluate(ctx, *u, stats, thresholds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 1 || ns[0].Resource != database.UsageStorage || ns[0].Threshold != 80 || ns[0].ID.IsZero() {
+		t.Fatalf("Expected a single storage notification at 80%%, got %+v", ns)
+	}
+	// Evaluating the same usage again shouldn't notify the user again.
+	ns, err = db.NotificationsEvaluate(ctx, *u, stats, thresholds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 0 {
+		t.Fatalf("Expected no new notifications, got %+v", ns)
+	}
+	// Reach the storage limit.
+	stats.StorageUsed = limits.Storage
+	ns, err = db.NotificationsEvaluate(ctx, *u, stats, thresholds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 1 || ns[0].Resource != database.UsageStorage || ns[0].Threshold != 100 {
+		t.Fatalf("Expected a single storage notification at 100%%, got %+v", ns)
+	}
+
+	// Both notifications should be listed, most recent first.
+	all, err := db.NotificationsByUser(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Threshold != 100 || all[1].Threshold != 80 {
+		t.Fatalf("Expected two notifications, got %+v", all)
+	}
+
+	// Deleting the user should delete their notifications.
+	err = db.UserDelete(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err = db.NotificationsByUser(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("Expected no notifications after deleting the user, got %+v", all)
+	}
+}
@@ -0,0 +1,199 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestPromoCode ensures promo codes grant their tier and respect their limits.
+func TestPromoCode(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add two test users.
+	u1, err := db.UserCreate(ctx, string(fastrand.Bytes(userSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u1)
+	u2, err := db.UserCreate(ctx, string(fastrand.Bytes(userSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u2)
+
+	// Create a code which can be redeemed once.
+	pc, err := db.PromoCodeCreate(ctx, database.PromoCode{Tier: database.TierPremium20, Months: 3, MaxRedemptions: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc.Code == "" {
+		t.Fatal("Expected a generated code")
+	}
+	_, err = db.PromoCodeCreate(ctx, database.PromoCode{Code: pc.Code, Tier: database.TierPremium5, Months: 1})
+	if !errors.Contains(err, database.ErrPromoCodeExists) {
+		t.Fatalf("Expected %v, got %v", database.ErrPromoCodeExists, err)
+	}
+
+	// Redeem it.
+	pr, err := db.PromoCodeRedeem(ctx, u1, pc.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Now().UTC().AddDate(0, 3, 0)
+	if u1.Tier != database.TierPremium20 || u1.SubscribedUntil.Sub(expected) > time.Minute || expected.Sub(u1.SubscribedUntil) > time.Minute {
+		t.Fatalf("Expected tier %d until around %v, got %d until %v", database.TierPremium20, expected, u1.Tier, u1.SubscribedUntil)
+	}
+	if !pr.SubscribedUntil.Equal(u1.SubscribedUntil) {
+		t.Fatalf("Expected the redemption to match the user's subscription, got %v and %v", pr.SubscribedUntil, u1.SubscribedUntil)
+	}
+	// The same user can't redeem it twice and nobody can redeem it once it's
+	// exhausted.
+	_, err = db.PromoCodeRedeem(ctx, u1, pc.Code)
+	if !errors.Contains(err, database.ErrPromoCodeExhausted) && !errors.Contains(err, database.ErrPromoCodeRedeemed) {
+		t.Fatalf("Expected the second redemption to fail, got %v", err)
+	}
+	_, err = db.PromoCodeRedeem(ctx, u2, pc.Code)
+	if !errors.Contains(err, database.ErrPromoCodeExhausted) {
+		t.Fatalf("Expected %v, got %v", database.ErrPromoCodeExhausted, err)
+	}
+	fpc, err := db.PromoCodeByCode(ctx, pc.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fpc.Redemptions != 1 {
+		t.Fatalf("Expected 1 redemption, got %d", fpc.Redemptions)
+	}
+	rs, err := db.PromoRedemptionsByCode(ctx, *fpc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || rs[0].UserID != u1.ID {
+		t.Fatalf("Unexpected redemptions %+v", rs)
+	}
+
+	// Redeeming an unlimited code for the same tier extends the subscription.
+	// Codes are case-insensitive.
+	pc2, err := db.PromoCodeCreate(ctx, database.PromoCode{Tier: database.TierPremium20, Months: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	until := u1.SubscribedUntil
+	_, err = db.PromoCodeRedeem(ctx, u1, " "+pc2.Code+" ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u1.SubscribedUntil.Equal(until.AddDate(0, 1, 0)) {
+		t.Fatalf("Expected the subscription to be extended to %v, got %v", until.AddDate(0, 1, 0), u1.SubscribedUntil)
+	}
+	_, err = db.PromoCodeRedeem(ctx, u1, pc2.Code)
+	if !errors.Contains(err, database.ErrPromoCodeRedeemed) {
+		t.Fatalf("Expected %v, got %v", database.ErrPromoCodeRedeemed, err)
+	}
+
+	// Codes for lower tiers and expired codes can't be redeemed.
+	pc3, err := db.PromoCodeCreate(ctx, database.PromoCode{Tier: database.TierPremium5, Months: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.PromoCodeRedeem(ctx, u1, pc3.Code)
+	if !errors.Contains(err, database.ErrPromoCodeTierTooLow) {
+		t.Fatalf("Expected %v, got %v", database.ErrPromoCodeTierTooLow, err)
+	}
+	pc4, err := db.PromoCodeCreate(ctx, database.PromoCode{Tier: database.TierPremium5, Months: 1, ExpiresAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.PromoCodeRedeem(ctx, u2, pc4.Code)
+	if !errors.Contains(err, database.ErrPromoCodeExpired) {
+		t.Fatalf("Expected %v, got %v", database.ErrPromoCodeExpired, err)
+	}
+	_, err = db.PromoCodeRedeem(ctx, u2, "no such code")
+	if !errors.Contains(err, database.ErrPromoCodeNotFound) {
+		t.Fatalf("Expected %v, got %v", database.ErrPromoCodeNotFound, err)
+	}
+}
+
+// TestPromoCodeUserDelete ensures that deleting a user keeps their promo code
+// redemptions and doesn't allow them to redeem the same code again.
+func TestPromoCodeUserDelete(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := string(fastrand.Bytes(userSubLen))
+	u, err := db.UserCreate(ctx, sub, database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u)
+	pc, err := db.PromoCodeCreate(ctx, database.PromoCode{Tier: database.TierPremium20, Months: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.PromoCodeRedeem(ctx, u, pc.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.UserDelete(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The code's report should still include the redemption, detached from
+	// the deleted user.
+	fpc, err := db.PromoCodeByCode(ctx, pc.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := db.PromoRedemptionsByCode(ctx, *fpc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fpc.Redemptions != 1 || len(rs) != 1 {
+		t.Fatalf("Expected 1 redemption, got %d and %+v", fpc.Redemptions, rs)
+	}
+	if !rs[0].UserID.IsZero() {
+		t.Fatalf("Expected the redemption to be detached from the user, got %v", rs[0].UserID)
+	}
+
+	// The same sub can't redeem the code again after signing up again.
+	u2, err := db.UserCreate(ctx, sub, database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u2)
+	_, err = db.PromoCodeRedeem(ctx, u2, pc.Code)
+	if !errors.Contains(err, database.ErrPromoCodeRedeemed) {
+		t.Fatalf("Expected %v, got %v", database.ErrPromoCodeRedeemed, err)
+	}
+	fpc, err = db.PromoCodeByCode(ctx, pc.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fpc.Redemptions != 1 {
+		t.Fatalf("Expected 1 redemption, got %d", fpc.Redemptions)
+	}
+	if u2.Tier != database.TierFree {
+		t.Fatalf("Expected tier %d, got %d", database.TierFree, u2.Tier)
+	}
+}
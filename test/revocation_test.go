@@ -0,0 +1,51 @@
+package test
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestTokenRevoke ensures TokenRevoke and TokenIsRevoked work as expected.
+func TestTokenRevoke(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jti := hex.EncodeToString(fastrand.Bytes(16))
+	revoked, err := db.TokenIsRevoked(ctx, jti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Fatal("Expected a fresh token not to be revoked.")
+	}
+	err = db.TokenRevoke(ctx, jti, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err = db.TokenIsRevoked(ctx, jti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("Expected the token to be revoked.")
+	}
+	// Revoking the same token again should not fail.
+	err = db.TokenRevoke(ctx, jti, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Revoking a token without a jti should fail.
+	err = db.TokenRevoke(ctx, "", time.Now().Add(time.Hour))
+	if err == nil {
+		t.Fatal("Expected an error when revoking a token without a jti.")
+	}
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestSession ensures we can record, list and revoke sessions.
+func TestSession(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a test user.
+	sub := string(fastrand.Bytes(userSubLen))
+	u, err := db.UserCreate(ctx, sub, database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u)
+
+	// Record a session twice. This should result in a single session.
+	now := time.Now().UTC()
+	s := database.Session{
+		SessionID: string(fastrand.Bytes(userSubLen)),
+		UserID:    u.ID,
+		JTI:       "jti1",
+		UserAgent: "test agent",
+		IP:        "1.2.3.4",
+		FirstSeen: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	err = db.SessionTouch(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.JTI = "jti2"
+	s.LastSeen = now.Add(time.Minute)
+	err = db.SessionTouch(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := db.SessionsByUser(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(sessions))
+	}
+	if sessions[0].JTI != "jti2" || sessions[0].UserAgent != s.UserAgent || sessions[0].IP != s.IP {
+		t.Fatalf("Unexpected session %+v", sessions[0])
+	}
+
+	// Revoke the session.
+	revoked, err := db.SessionIsRevoked(ctx, s.SessionID)
+	if err != nil || revoked {
+		t.Fatalf("Expected the session not to be revoked, got %t, %v", revoked, err)
+	}
+	err = db.SessionRevoke(ctx, *u, "no such session")
+	if !errors.Contains(err, database.ErrSessionNotFound) {
+		t.Fatalf("Expected error %v, got %v", database.ErrSessionNotFound, err)
+	}
+	err = db.SessionRevoke(ctx, *u, s.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err = db.SessionIsRevoked(ctx, s.SessionID)
+	if err != nil || !revoked {
+		t.Fatalf("Expected the session to be revoked, got %t, %v", revoked, err)
+	}
+	// Revoked sessions are not listed.
+	sessions, err = db.SessionsByUser(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Fatalf("Expected no sessions, got %d", len(sessions))
+	}
+}
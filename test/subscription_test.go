@@ -0,0 +1,254 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestUserChangeSubscription ensures upgrades apply immediately, downgrades
+// are scheduled and applied once they are due, and all changes are recorded.
+func TestUserChangeSubscription(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a test user.
+	sub := string(fastrand.Bytes(userSubLen))
+	u, err := db.UserCreate(ctx, sub, database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u)
+
+	// Upgrade the user. This should apply immediately.
+	until := time.Now().UTC().AddDate(0, 1, 0).Truncate(time.Millisecond)
+	sc, err := db.UserChangeSubscription(ctx, u, database.TierPremium20, until, database.SubscriptionSourceAdmin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Status != database.SubscriptionStatusApplied || u.Tier != database.TierPremium20 || !u.SubscribedUntil.Equal(until) {
+		t.Fatalf("Expected the upgrade to apply immediately, got %+v, user %+v", sc, u)
+	}
+	// An invalid tier should fail.
+	_, err = db.UserChangeSubscription(ctx, u, database.TierReserved, until, database.SubscriptionSourceAdmin, false)
+	if !errors.Contains(err, database.ErrInvalidTier) {
+		t.Fatalf("Expected %v, got %v", database.ErrInvalidTier, err)
+	}
+	// A change based on stale data should fail.
+	stale := *u
+	stale.Tier = database.TierFree
+	_, err = db.UserChangeSubscription(ctx, &stale, database.TierPremium5, until, database.SubscriptionSourceAdmin, false)
+	if !errors.Contains(err, database.ErrSubscriptionChanged) {
+		t.Fatalf("Expected %v, got %v", database.ErrSubscriptionChanged, err)
+	}
+
+	// Downgrade the user. This should be scheduled.
+	sc, err = db.UserChangeSubscription(ctx, u, database.TierPremium5, until, database.SubscriptionSourceUser, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Status != database.SubscriptionStatusScheduled || u.Tier != database.TierPremium20 || u.PendingTier != database.TierPremium5 {
+		t.Fatalf("Expected the downgrade to be scheduled, got %+v, user %+v", sc, u)
+	}
+	if !sc.EffectiveAt.After(time.Now()) || !u.PendingTierAt.Equal(sc.EffectiveAt) {
+		t.Fatalf("Expected the downgrade to be scheduled in the future, got %v", sc.EffectiveAt)
+	}
+	// Nothing should be due yet.
+	n, err := db.SubscriptionsApplyScheduled(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fu, err := db.UserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fu.Tier != database.TierPremium20 {
+		t.Fatalf("Expected tier %d, got %d (applied %d)", database.TierPremium20, fu.Tier, n)
+	}
+	// Apply the scheduled change once it's due.
+	_, err = db.SubscriptionsApplyScheduled(ctx, sc.EffectiveAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fu, err = db.UserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fu.Tier != database.TierPremium5 || fu.PendingTier != database.TierReserved || !fu.PendingTierAt.IsZero() {
+		t.Fatalf("Expected the scheduled downgrade to be applied, got %+v", fu)
+	}
+
+	// Make sure all changes are in the history, most recent first.
+	changes, err := db.SubscriptionChangesByUser(ctx, *fu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("Expected 3 changes, got %d", len(changes))
+	}
+	if changes[0].Source != database.SubscriptionSourceScheduled || changes[0].ToTier != database.TierPremium5 {
+		t.Fatalf("Unexpected last change %+v", changes[0])
+	}
+	if changes[2].FromTier != database.TierFree || changes[2].ToTier != database.TierPremium20 {
+		t.Fatalf("Unexpected first change %+v", changes[2])
+	}
+}
+
+// TestSubscriptionsExpire ensures users whose subscription expired are moved
+// to the Free tier once the grace period has passed.
+func TestSubscriptionsExpire(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a test user with an expired subscription and one without an
+	// expiration.
+	expired, err := db.UserCreate(ctx, string(fastrand.Bytes(userSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(expired)
+	until := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+	_, err = db.UserChangeSubscription(ctx, expired, database.TierPremium20, until, database.SubscriptionSourceAdmin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlimited, err := db.UserCreate(ctx, string(fastrand.Bytes(userSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(unlimited)
+	_, err = db.UserChangeSubscription(ctx, unlimited, database.TierPremium20, time.Time{}, database.SubscriptionSourceAdmin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Add a test user whose promo code subscription expired.
+	promo, err := db.UserCreate(ctx, string(fastrand.Bytes(userSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(promo)
+	_, err = db.UserChangeSubscription(ctx, promo, database.TierPremium20, until, database.SubscriptionSourcePromo, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The user should keep their tier during the grace period.
+	_, err = db.SubscriptionsExpire(ctx, time.Now(), 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := db.UserByID(ctx, expired.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Tier != database.TierPremium20 {
+		t.Fatalf("Expected tier %d during the grace period, got %d", database.TierPremium20, u.Tier)
+	}
+	// Promo code subscriptions get no grace period.
+	u, err = db.UserByID(ctx, promo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Tier != database.TierFree {
+		t.Fatalf("Expected tier %d for an expired promo code subscription, got %d", database.TierFree, u.Tier)
+	}
+	changes, err := db.SubscriptionChangesByUser(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || !changes[0].EffectiveAt.Equal(until) {
+		t.Fatalf("Expected the expiration to take effect at %v, got %+v", until, changes)
+	}
+	// And lose it after that. Running the expiration again, e.g. on another
+	// instance of the service, should not change anything.
+	for i := 0; i < 2; i++ {
+		_, err = db.SubscriptionsExpire(ctx, time.Now(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	u, err = db.UserByID(ctx, expired.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Tier != database.TierFree {
+		t.Fatalf("Expected tier %d after the grace period, got %d", database.TierFree, u.Tier)
+	}
+	changes, err = db.SubscriptionChangesByUser(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Source != database.SubscriptionSourceExpiry {
+		t.Fatalf("Expected the expiration to be recorded once, got %+v", changes)
+	}
+	// The user without an expiration should keep their tier.
+	u, err = db.UserByID(ctx, unlimited.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Tier != database.TierPremium20 {
+		t.Fatalf("Expected tier %d for a user without expiration, got %d", database.TierPremium20, u.Tier)
+	}
+}
+
+// TestUserSetStripeSubscription ensures we refuse to record a subscription
+// event which is older than the last one we applied.
+func TestUserSetStripeSubscription(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := string(fastrand.Bytes(userSubLen))
+	u, err := db.UserCreate(ctx, sub, database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u)
+
+	created := time.Now().UTC().Truncate(time.Second)
+	err = db.UserSetStripeSubscription(ctx, u, "sub_new", created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An older event, e.g. the deletion of the user's previous subscription,
+	// which Stripe delivered late.
+	err = db.UserSetStripeSubscription(ctx, u, "sub_old", created.Add(-time.Minute))
+	if !errors.Contains(err, database.ErrStaleStripeEvent) {
+		t.Fatalf("Expected %v, got %v", database.ErrStaleStripeEvent, err)
+	}
+	// Events created in the same second are fine.
+	err = db.UserSetStripeSubscription(ctx, u, "sub_new", created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = db.UserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.StripeSubscriptionID != "sub_new" || !u.StripeEventCreatedAt.Equal(created) {
+		t.Fatalf("Unexpected subscription %s created at %v", u.StripeSubscriptionID, u.StripeEventCreatedAt)
+	}
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+	"github.com/NebulousLabs/skynet-accounts/skynet"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestUserUsageSeries ensures we report the users' usage per day and hour.
+func TestUserUsageSeries(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a test user.
+	u, err := db.UserCreate(ctx, string(fastrand.Bytes(userSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		_ = db.UserDelete(ctx, user)
+	}(u)
+
+	// Create some usage.
+	size := int64(skynet.MiB)
+	skylink, err := createTestUpload(ctx, db, u, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DownloadCreate(ctx, *u, *skylink, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = db.RegistryReadCreate(ctx, *u)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.RegistryWriteCreate(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the usage in the last 24 hours.
+	now := time.Now().UTC()
+	period := database.BillingPeriod{Start: now.Add(-24 * time.Hour), End: now.Add(time.Minute)}
+	for _, bucket := range []string{database.UsageBucketDay, database.UsageBucketHour} {
+		series, err := db.UserUsageSeries(ctx, *u, period, bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(series.Items) < 2 {
+			t.Fatalf("Expected at least two %s buckets, got %d", bucket, len(series.Items))
+		}
+		var total database.UsageBucket
+		for _, b := range series.Items {
+			total.NumUploads += b.NumUploads
+			total.TotalUploadsSize += b.TotalUploadsSize
+			total.NumDownloads += b.NumDownloads
+			total.TotalDownloadsSize += b.TotalDownloadsSize
+			total.NumRegReads += b.NumRegReads
+			total.NumRegWrites += b.NumRegWrites
+			total.BandwidthRegReads += b.BandwidthRegReads
+			total.BandwidthRegWrites += b.BandwidthRegWrites
+		}
+		if total.NumUploads != 1 || total.TotalUploadsSize != size ||
+			total.NumDownloads != 1 || total.TotalDownloadsSize != 1024 ||
+			total.NumRegReads != 2 || total.NumRegWrites != 1 ||
+			total.BandwidthRegReads != 2*skynet.PriceBandwidthRegistryRead ||
+			total.BandwidthRegWrites != skynet.PriceBandwidthRegistryWrite {
+			t.Fatalf("Unexpected %s totals %+v", bucket, total)
+		}
+	}
+
+	// Hourly series are limited to shorter windows.
+	period.Start = now.Add(-database.MaxHourlyStatsRange - time.Hour)
+	_, err = db.UserUsageSeries(ctx, *u, period, database.UsageBucketHour)
+	if !errors.Contains(err, database.ErrInvalidPeriod) {
+		t.Fatalf("Expected %v, got %v", database.ErrInvalidPeriod, err)
+	}
+	_, err = db.UserUsageSeries(ctx, *u, period, database.UsageBucketDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,10 +3,12 @@ package test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/NebulousLabs/skynet-accounts/database"
 	"github.com/NebulousLabs/skynet-accounts/skynet"
 
+	"gitlab.com/NebulousLabs/errors"
 	"gitlab.com/NebulousLabs/fastrand"
 )
 
@@ -47,6 +49,9 @@ func TestUserStats(t *testing.T) {
 	if stats.NumUploads != 1 {
 		t.Fatalf("Expected a total of %d uploads, got %d.", 1, stats.NumUploads)
 	}
+	if now := time.Now(); now.Before(stats.PeriodStart) || !now.Before(stats.PeriodEnd) {
+		t.Fatalf("Expected the stats to cover the current period, got %v - %v.", stats.PeriodStart, stats.PeriodEnd)
+	}
 	if stats.BandwidthUploads != expectedUploadBandwidth {
 		t.Fatalf("Expected upload bandwidth of %d (%d MiB), got %d (%d MiB).",
 			expectedUploadBandwidth, expectedUploadBandwidth/skynet.MiB,
@@ -191,4 +196,35 @@ func TestUserStats(t *testing.T) {
 			expectedRegWriteBandwidth, expectedRegWriteBandwidth/skynet.MiB,
 			stats.BandwidthRegWrites, stats.BandwidthRegWrites/skynet.MiB)
 	}
+
+	// The previous period should be empty.
+	prev := database.BillingPeriodsAgo(u.SubscribedUntil, time.Now(), 1)
+	stats, err = db.UserStatsForPeriod(ctx, *u, prev)
+	if err != nil {
+		t.Fatal("Failed to fetch user stats for the previous period.", err)
+	}
+	if stats.NumUploads != 0 || stats.NumDownloads != 0 || stats.NumRegReads != 0 || stats.NumRegWrites != 0 {
+		t.Fatalf("Expected no usage in the previous period, got %+v.", stats)
+	}
+	if !stats.PeriodStart.Equal(prev.Start) || !stats.PeriodEnd.Equal(prev.End) {
+		t.Fatalf("Expected the period %v - %v, got %v - %v.", prev.Start, prev.End, stats.PeriodStart, stats.PeriodEnd)
+	}
+	// A custom window which covers everything should report all usage.
+	now := time.Now().UTC()
+	stats, err = db.UserStatsForPeriod(ctx, *u, database.BillingPeriod{Start: now.Add(-time.Hour), End: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal("Failed to fetch user stats for a custom window.", err)
+	}
+	if stats.NumRegReads != 2 || stats.NumRegWrites != 2 {
+		t.Fatalf("Expected 2 registry reads and writes, got %d and %d.", stats.NumRegReads, stats.NumRegWrites)
+	}
+	// Empty and too long windows are invalid.
+	_, err = db.UserStatsForPeriod(ctx, *u, database.BillingPeriod{Start: now, End: now})
+	if !errors.Contains(err, database.ErrInvalidPeriod) {
+		t.Fatalf("Expected %v, got %v.", database.ErrInvalidPeriod, err)
+	}
+	_, err = db.UserStatsForPeriod(ctx, *u, database.BillingPeriod{Start: now.Add(-database.MaxStatsRange - time.Hour), End: now})
+	if !errors.Contains(err, database.ErrInvalidPeriod) {
+		t.Fatalf("Expected %v, got %v.", database.ErrInvalidPeriod, err)
+	}
 }
@@ -0,0 +1,43 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NebulousLabs/skynet-accounts/database"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestWebhookEvent ensures we recognise webhook events we've already received
+// and can forget them.
+func TestWebhookEvent(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.New(ctx, DBTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := "evt_" + string(fastrand.Bytes(userSubLen))
+	err = db.WebhookEventCreate(ctx, id, "customer.subscription.updated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = db.WebhookEventDelete(ctx, id)
+	}()
+	err = db.WebhookEventCreate(ctx, id, "customer.subscription.updated")
+	if !errors.Contains(err, database.ErrWebhookEventExists) {
+		t.Fatalf("Expected %v, got %v", database.ErrWebhookEventExists, err)
+	}
+	// Once we forget the event, we should be able to receive it again.
+	err = db.WebhookEventDelete(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.WebhookEventCreate(ctx, id, "customer.subscription.updated")
+	if err != nil {
+		t.Fatal(err)
+	}
+}